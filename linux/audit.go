@@ -0,0 +1,151 @@
+package linux
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/pkg/errors"
+)
+
+// auditLog appends a JSON record to a file for every command run on the host.
+type auditLog struct {
+	host string
+
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// auditRecord is a line of the audit log. The input of commands, such as file content, is never recorded,
+// only its size. Passwords are never part of commands, so they can't end up in the log either.
+type auditRecord struct {
+	Time     string `json:"time"`
+	Host     string `json:"host"`
+	Resource string `json:"resource,omitempty"`
+	Command  string `json:"command"`
+	Sudo     bool   `json:"sudo"`
+	RunAs    string `json:"run_as,omitempty"`
+	Stdin    string `json:"stdin,omitempty"`
+	ExitCode *int   `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+	Duration int64  `json:"duration_ms"`
+}
+
+func openAuditLog(path string, host string) (*auditLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to open audit_log")
+	}
+	return &auditLog{host: host, file: file}, nil
+}
+
+// record adds a line to the log. Failing to write it fails the command, so that nothing runs unaudited.
+func (a *auditLog) record(ctx context.Context, record auditRecord) error {
+	record.Host = a.host
+	record.Resource = auditResource(ctx)
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	// A single write per line keeps the lines whole when several providers append to the same file.
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		return errors.Wrap(err, "Unable to write to audit_log")
+	}
+	return nil
+}
+
+// auditCommand records a command that was run, or couldn't be. exitStatus is ignored when runErr is set.
+func (c *Client) auditCommand(ctx context.Context, command string, sudo bool, stdin string, start time.Time, exitStatus int, runErr error) error {
+	if c.audit == nil {
+		return nil
+	}
+	record := auditRecord{
+		Time:     start.UTC().Format(time.RFC3339Nano),
+		Command:  command,
+		Sudo:     sudo,
+		RunAs:    c.runAs,
+		Duration: time.Since(start).Milliseconds(),
+	}
+	if stdin != "" {
+		record.Stdin = fmt.Sprintf("[redacted %d bytes]", len(stdin))
+	}
+	if runErr != nil {
+		record.Error = runErr.Error()
+	} else {
+		record.ExitCode = &exitStatus
+	}
+	return c.audit.record(ctx, record)
+}
+
+// auditSFTP runs request, an SFTP operation on path, and records it as the command "sftp <operation> <path>".
+func (c *Client) auditSFTP(ctx context.Context, operation string, path string, request func() error) error {
+	start := time.Now()
+	err := request()
+	if c.audit == nil {
+		return err
+	}
+	// The outcome of SFTP requests has no exit code, so failures are recorded with status 1.
+	status := 0
+	if err != nil {
+		status = 1
+	}
+	if auditErr := c.auditCommand(ctx, shellCommand("sftp", operation, path), false, "", start, status, nil); auditErr != nil {
+		return auditErr
+	}
+	return err
+}
+
+type auditResourceKey struct{}
+
+// withAuditResource returns a context that attributes the commands run with it to resource.
+func withAuditResource(ctx context.Context, resource string) context.Context {
+	return context.WithValue(ctx, auditResourceKey{}, resource)
+}
+
+func auditResource(ctx context.Context) string {
+	resource, _ := ctx.Value(auditResourceKey{}).(string)
+	return resource
+}
+
+// auditedResource makes the CRUD functions of resource, registered as name, attribute their commands to
+// it. Providers aren't told the address of resources in the configuration, so the resource is recorded as
+// its type and ID, such as linux_file./etc/motd. Until it's created, its path or name stands in for the ID.
+func auditedResource(name string, resource *schema.Resource) *schema.Resource {
+	wrap := func(f func(context.Context, *schema.ResourceData, interface{}) diag.Diagnostics) func(context.Context, *schema.ResourceData, interface{}) diag.Diagnostics {
+		if f == nil {
+			return nil
+		}
+		return func(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+			address := name
+			if id := auditID(resource, d); id != "" {
+				address = fmt.Sprintf("%s.%s", name, id)
+			}
+			return f(withAuditResource(ctx, address), d, m)
+		}
+	}
+	resource.CreateContext = wrap(resource.CreateContext)
+	resource.ReadContext = wrap(resource.ReadContext)
+	resource.UpdateContext = wrap(resource.UpdateContext)
+	resource.DeleteContext = wrap(resource.DeleteContext)
+	return resource
+}
+
+func auditID(resource *schema.Resource, d *schema.ResourceData) string {
+	if id := d.Id(); id != "" {
+		return id
+	}
+	for _, key := range []string{"path", "name"} {
+		if _, ok := resource.Schema[key]; ok {
+			return fmt.Sprintf("%v", d.Get(key))
+		}
+	}
+	return ""
+}
@@ -0,0 +1,88 @@
+package linux
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func readAuditLog(t *testing.T, path string) []auditRecord {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var records []auditRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("Audit log line isn't JSON: %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := openAuditLog(path, "testhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, _ := testFakeClient(map[string]fakeResult{
+		"ls -ld /etc/testfile":         {stdout: "-rw------- 1 root root 13 Jan  1 00:00 /etc/testfile\n"},
+		"cat /etc/testfile":            {stdout: "secretcontent"},
+		"sudo chmod 600 /etc/testfile": {exitStatus: 1},
+	})
+	client.audit = audit
+	client.useSudo = true
+
+	resource := auditedResource("linux_file", fileResource())
+	d := schema.TestResourceDataRaw(t, resource.Schema, map[string]interface{}{
+		"path":    "/etc/testfile",
+		"content": "secretcontent",
+	})
+	if diags := resource.CreateContext(context.Background(), d, client); diags.HasError() {
+		t.Fatalf("Unable to create file: %v", diags)
+	}
+	runCommand(context.Background(), client, true, "chmod 600 /etc/testfile", "")
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "secretcontent") {
+		t.Errorf("File content should be redacted from the audit log:\n%s", content)
+	}
+
+	records := readAuditLog(t, path)
+	var commands []string
+	for _, record := range records {
+		commands = append(commands, record.Command)
+	}
+	expected := []string{"touch /etc/testfile", "cat > /etc/testfile", "ls -ld /etc/testfile", "cat /etc/testfile", "chmod 600 /etc/testfile"}
+	if strings.Join(commands, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Unexpected audited commands: %q", commands)
+	}
+
+	write := records[1]
+	if write.Host != "testhost" || write.Resource != "linux_file./etc/testfile" || write.Sudo || write.Time == "" {
+		t.Errorf("Unexpected record: %+v", write)
+	}
+	if write.Stdin != "[redacted 13 bytes]" || write.ExitCode == nil || *write.ExitCode != 0 {
+		t.Errorf("Unexpected record: %+v", write)
+	}
+	chmod := records[4]
+	if chmod.Resource != "" || !chmod.Sudo || chmod.ExitCode == nil || *chmod.ExitCode != 1 {
+		t.Errorf("Unexpected record: %+v", chmod)
+	}
+}
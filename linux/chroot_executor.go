@@ -0,0 +1,27 @@
+package linux
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// chrootExecutor runs commands on the local machine, chrooted into the root filesystem of an image that
+// isn't booted. Paths used by the resources resolve inside that root.
+type chrootExecutor struct {
+	root string
+}
+
+func (e *chrootExecutor) Execute(ctx context.Context, command string, stdin io.Reader, stdout io.Writer, stderr io.Writer) (int, error) {
+	return runLocal(ctx, e.command(ctx, command), stdin, stdout, stderr)
+}
+
+func (e *chrootExecutor) command(ctx context.Context, command string) *exec.Cmd {
+	args := []string{"chroot", e.root, "/bin/sh", "-c", command}
+	if os.Geteuid() != 0 {
+		// chroot needs root, and the commands run as root inside the image anyway.
+		args = append([]string{"sudo", "-n"}, args...)
+	}
+	return exec.CommandContext(ctx, args[0], args[1:]...)
+}
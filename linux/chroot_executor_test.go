@@ -0,0 +1,38 @@
+package linux
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestChrootExecutorCommand(t *testing.T) {
+	executor := &chrootExecutor{root: "/srv/rootfs"}
+	args := executor.command(context.Background(), "getent passwd root").Args
+
+	expected := []string{"chroot", "/srv/rootfs", "/bin/sh", "-c", "getent passwd root"}
+	if os.Geteuid() != 0 {
+		expected = append([]string{"sudo", "-n"}, expected...)
+	}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected %v, got %v", expected, args)
+	}
+}
+
+func TestChrootRequiresDirectory(t *testing.T) {
+	config := Config{ConnectionType: connectionTypeChroot}
+	if _, err := config.Client(); err == nil {
+		t.Errorf("Chroot connection without a directory should be rejected")
+	}
+
+	config.ChrootDirectory = "/nonexistent"
+	if _, err := config.Client(); err == nil {
+		t.Errorf("Chroot connection into a missing directory should be rejected")
+	}
+
+	config.ChrootDirectory = t.TempDir()
+	if _, err := config.Client(); err != nil {
+		t.Errorf("Chroot connection into a directory should be accepted: %v", err)
+	}
+}
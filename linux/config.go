@@ -1,73 +1,513 @@
 package linux
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net"
 	"os"
+	"regexp"
 	"strings"
+	"time"
 
+	"github.com/pkg/errors"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
 )
 
+const (
+	connectionTypeSSH       = "ssh"
+	connectionTypeLocal     = "local"
+	connectionTypeContainer = "container"
+	connectionTypeChroot    = "chroot"
+)
+
 type Config struct {
-	Host       string
-	Port       int
-	User       string
-	Password   string
-	PrivateKey string
-	UseSudo    bool
+	ConnectionType       string
+	Container            string
+	ContainerRuntime     string
+	ChrootDirectory      string
+	Host                 string
+	Port                 int
+	User                 string
+	Password             string
+	PrivateKey           string
+	PrivateKeyPEM        string
+	PrivateKeyPassphrase string
+	Certificate          string
+	KeyboardInteractive  []KeyboardInteractiveAnswer
+	KnownHostsFile       string
+	HostKeys             []string
+	TrustOnFirstUse      bool
+	// InsecureIgnoreHostKey skips the verification of the host key, if there's nothing to verify it with.
+	InsecureIgnoreHostKey bool
+	ConnectTimeout        time.Duration
+	WaitForReady          time.Duration
+	KeepaliveInterval     time.Duration
+	MaxSessions           int
+	UseSudo               bool
+	Escalation            Escalation
+	FileTransfer          string
+	AuditLog              string
+	ScriptExport          string
+	ReadOnly              bool
+
+	// Environment is set for every command, on top of defaultEnvironment.
+	Environment map[string]string
+
+	// ProtectedPaths and ManagedPaths restrict where files and folders are created, moved and deleted, see
+	// Client.checkPath.
+	ProtectedPaths []string
+	ManagedPaths   []string
+
+	// Bastions are the jump hosts the connection is tunnelled through, in order.
+	Bastions []Config
 }
 
+// KeyboardInteractiveAnswer answers the keyboard-interactive prompts that match Prompt.
+type KeyboardInteractiveAnswer struct {
+	Prompt *regexp.Regexp
+	Answer string
+}
+
+var passwordPrompt = regexp.MustCompile(`(?i)password`)
+
 type Client struct {
-	connection *ssh.Client
-	useSudo    bool
+	executor     Executor
+	useSudo      bool
+	escalation   Escalation
+	fileTransfer string
+
+	// audit records the commands that are run, if AuditLog is set.
+	audit *auditLog
+
+	// script collects the commands that change the host instead of running them, if ScriptExport is set.
+	script *scriptExport
+
+	// readOnly refuses changes to the host, and any command that isn't known to only read from it.
+	readOnly bool
+
+	protectedPaths []string
+	managedPaths   []string
+
+	// environment is the environment commands run with.
+	environment map[string]string
+
+	// cachedFacts holds the facts of the host once they're gathered.
+	cachedFacts *factsCache
+
+	// sessions limits how many commands run at once, if MaxSessions is set. A command holds a slot in it
+	// while it runs.
+	sessions chan struct{}
+
+	// runAs is the user that commands which don't need root privileges run as, if it isn't the one the
+	// connection is made with.
+	runAs string
 }
 
-func (c *Config) Client() (*Client, error) {
+// as returns a client that runs the commands which don't need root privileges as user.
+func (c *Client) as(user string) *Client {
+	if user == "" {
+		return c
+	}
+	client := *c
+	client.runAs = user
+	return &client
+}
+
+func (c *Config) parsePrivateKey(key []byte) (ssh.Signer, error) {
+	signer, err := ssh.ParsePrivateKey(key)
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		if c.PrivateKeyPassphrase == "" {
+			return nil, fmt.Errorf("The private key is encrypted, set private_key_passphrase to use it")
+		}
+		return ssh.ParsePrivateKeyWithPassphrase(key, []byte(c.PrivateKeyPassphrase))
+	}
+	return signer, err
+}
+
+// loadCertificate parses Certificate, which holds either the content of an OpenSSH user certificate or
+// the location of one.
+func (c *Config) loadCertificate() (*ssh.Certificate, error) {
+	content := []byte(c.Certificate)
+	if !strings.Contains(c.Certificate, "-cert-v01@openssh.com ") {
+		var err error
+		content, err = ioutil.ReadFile(os.ExpandEnv(c.Certificate))
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to read certificate")
+		}
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(content)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to parse certificate")
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("certificate holds a %s public key, not a certificate", key.Type())
+	}
+	return cert, nil
+}
+
+// certSigners pairs cert with the signers holding its private key. The certificate signers come first,
+// followed by the plain signers.
+func certSigners(cert *ssh.Certificate, signers []ssh.Signer) ([]ssh.Signer, error) {
+	var withCert []ssh.Signer
+	certKey := cert.Key.Marshal()
+	for _, signer := range signers {
+		if !bytes.Equal(signer.PublicKey().Marshal(), certKey) {
+			continue
+		}
+		certSigner, err := ssh.NewCertSigner(cert, signer)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to use certificate")
+		}
+		withCert = append(withCert, certSigner)
+	}
+	if len(withCert) == 0 {
+		return nil, fmt.Errorf("The certificate doesn't match any of the private keys or agent keys")
+	}
+	return append(withCert, signers...), nil
+}
+
+// authMethods returns the auth methods to offer, in order: the inline private key, the private key file
+// and the keys from the SSH agent as one publickey method, then the password, then keyboard-interactive. If a certificate is set, it
+// is presented ahead of the plain keys with whichever of them holds its private key. It also returns a
+// description of each of them, to report what was tried if authentication fails.
+func (c *Config) authMethods() ([]ssh.AuthMethod, []string, error) {
 	var auths []ssh.AuthMethod
+	var tried []string
 
-	if c.Password != "" {
-		auths = append(auths, ssh.Password(c.Password))
-	} else {
-		keys := []ssh.Signer{}
+	keys := []ssh.Signer{}
+	var keySources []string
+	if c.PrivateKeyPEM != "" {
+		signer, err := c.parsePrivateKey([]byte(c.PrivateKeyPEM))
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "Unable to parse private_key_pem")
+		}
+		keys = append(keys, signer)
+		keySources = append(keySources, "private_key_pem")
+	}
+
+	if c.PrivateKey != "" {
+		isDefault := strings.HasSuffix(c.PrivateKey, "/.ssh/id_rsa")
 		key, err := ioutil.ReadFile(c.PrivateKey)
 		if err == nil {
-			signer, err := ssh.ParsePrivateKey(key)
+			signer, err := c.parsePrivateKey(key)
 			if err == nil {
 				keys = append(keys, signer)
+				keySources = append(keySources, c.PrivateKey)
+			} else if isDefault {
+				log.Printf("[WARN] Skipping private key %s: %s", c.PrivateKey, err)
+			} else {
+				return nil, nil, errors.Wrap(err, fmt.Sprintf("Unable to parse private key %s", c.PrivateKey))
 			}
-		} else if !os.IsNotExist(err) || !strings.HasSuffix(c.PrivateKey, "/.ssh/id_rsa") {
-			return nil, err
+		} else if !os.IsNotExist(err) || !isDefault {
+			return nil, nil, err
 		}
+	}
 
-		if sshAgent, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK")); err == nil {
-			signers, err := agent.NewClient(sshAgent).Signers()
-			if err == nil {
-				keys = append(keys, signers...)
-			}
+	if sshAgent, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK")); err == nil {
+		signers, err := agent.NewClient(sshAgent).Signers()
+		if err == nil && len(signers) > 0 {
+			keys = append(keys, signers...)
+			keySources = append(keySources, fmt.Sprintf("%d agent keys", len(signers)))
+		}
+	}
+
+	if c.Certificate != "" {
+		cert, err := c.loadCertificate()
+		if err != nil {
+			return nil, nil, err
+		}
+		keys, err = certSigners(cert, keys)
+		if err != nil {
+			return nil, nil, err
 		}
+		keySources = append([]string{"certificate"}, keySources...)
+	}
 
+	if len(keys) > 0 {
 		auths = append(auths, ssh.PublicKeys(keys...))
+		tried = append(tried, fmt.Sprintf("publickey (%s)", strings.Join(keySources, ", ")))
+	}
+
+	if c.Password != "" {
+		auths = append(auths, ssh.Password(c.Password))
+		tried = append(tried, "password")
+	}
+
+	if c.Password != "" || len(c.KeyboardInteractive) > 0 {
+		auths = append(auths, ssh.KeyboardInteractive(c.keyboardInteractiveChallenge))
+		tried = append(tried, "keyboard-interactive")
+	}
+	return auths, tried, nil
+}
+
+// keyboardInteractiveChallenge answers each question with the first configured answer whose prompt
+// matches it, and password prompts with the password.
+func (c *Config) keyboardInteractiveChallenge(name, instruction string, questions []string, echos []bool) ([]string, error) {
+	answers := make([]string, len(questions))
+	for i, question := range questions {
+		answered := false
+		for _, answer := range c.KeyboardInteractive {
+			if answer.Prompt.MatchString(question) {
+				answers[i] = answer.Answer
+				answered = true
+				break
+			}
+		}
+		if !answered && c.Password != "" && passwordPrompt.MatchString(question) {
+			answers[i] = c.Password
+			answered = true
+		}
+		if !answered {
+			return nil, fmt.Errorf("No answer configured for keyboard-interactive prompt %q", question)
+		}
+	}
+	return answers, nil
+}
+
+// clientConfig builds the SSH client config for the host described by c. It also returns the auth
+// methods that are offered, to report them if the handshake fails.
+func (c *Config) clientConfig() (*ssh.ClientConfig, []string, error) {
+	auths, tried, err := c.authMethods()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(auths) == 0 {
+		return nil, nil, fmt.Errorf("No auth methods available for %s@%s, set a password or a private key", c.User, c.Host)
+	}
+
+	hostKeyCallback, err := c.hostKeyCallback()
+	if err != nil {
+		return nil, nil, err
 	}
 
-	sshConfig := &ssh.ClientConfig{
+	return &ssh.ClientConfig{
 		User:            c.User,
 		Auth:            auths,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         c.ConnectTimeout,
+	}, tried, nil
+}
+
+// dial opens an SSH connection to the host described by c. If via is set, the TCP connection is
+// tunnelled through that client, the same way OpenSSH's ProxyJump does.
+func (c *Config) dial(ctx context.Context, via *ssh.Client, sshConfig *ssh.ClientConfig, tried []string) (*ssh.Client, error) {
+	withTried := func(err error) error {
+		return fmt.Errorf("%s (tried auth methods: %s)", err, strings.Join(tried, ", "))
+	}
+
+	address := fmt.Sprintf("%s:%d", c.Host, c.Port)
+	var conn net.Conn
+	var err error
+	if via == nil {
+		dialer := net.Dialer{Timeout: sshConfig.Timeout}
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	} else {
+		conn, err = via.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The handshake doesn't take a context, so the connection is closed under it if ctx is done first.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, address, sshConfig)
+	if !stop() {
+		if err == nil {
+			clientConn.Close()
+		}
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, withTried(err)
 	}
+	return ssh.NewClient(clientConn, chans, reqs), nil
+}
 
-	connection, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", c.Host, c.Port), sshConfig)
+type hop struct {
+	config    *Config
+	sshConfig *ssh.ClientConfig
+	tried     []string
+}
+
+// dialHops makes one attempt at connecting to the last hop, through each of the hops before it.
+func dialHops(ctx context.Context, hops []hop) (*ssh.Client, []*ssh.Client, error) {
+	var via *ssh.Client
+	var bastions []*ssh.Client
+	closeBastions := func() {
+		for i := len(bastions) - 1; i >= 0; i-- {
+			bastions[i].Close()
+		}
+	}
+
+	target := hops[len(hops)-1]
+	for _, bastion := range hops[:len(hops)-1] {
+		connection, err := bastion.config.dial(ctx, via, bastion.sshConfig, bastion.tried)
+		if err != nil {
+			closeBastions()
+			return nil, nil, fmt.Errorf("Failed to dial bastion %s:%d: %s", bastion.config.Host, bastion.config.Port, err)
+		}
+		log.Printf("Connected to bastion %s:%d", bastion.config.Host, bastion.config.Port)
+		bastions = append(bastions, connection)
+		via = connection
+	}
+
+	connection, err := target.config.dial(ctx, via, target.sshConfig, target.tried)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to dial: %s", err)
+		closeBastions()
+		return nil, nil, fmt.Errorf("Failed to dial: %s", err)
+	}
+	return connection, bastions, nil
+}
+
+const maxConnectBackoff = 30 * time.Second
+
+// connect dials the host through its bastions. Failed attempts are retried with exponential backoff
+// until WaitForReady has passed, so that hosts which are still booting can be waited for. It gives up as soon
+// as ctx is done.
+func (c *Config) connect(ctx context.Context) (*ssh.Client, []*ssh.Client, error) {
+	if c.Host == "" {
+		return nil, nil, fmt.Errorf("host is not set")
+	}
+
+	var hops []hop
+	for _, config := range append(append([]Config{}, c.Bastions...), *c) {
+		config := config
+		config.ConnectTimeout = c.ConnectTimeout
+		sshConfig, tried, err := config.clientConfig()
+		if err != nil {
+			return nil, nil, err
+		}
+		hops = append(hops, hop{config: &config, sshConfig: sshConfig, tried: tried})
 	}
 
-	log.Printf("SSH client configured")
+	deadline := time.Now().Add(c.WaitForReady)
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		connection, bastions, err := dialHops(ctx, hops)
+		if err == nil {
+			log.Printf("SSH client configured")
+			return connection, bastions, nil
+		}
+		if ctx.Err() != nil {
+			return nil, nil, errors.Wrap(ctx.Err(), "Gave up connecting")
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			if attempt > 1 {
+				err = errors.Wrap(err, fmt.Sprintf("Host not ready after %d attempts in %s", attempt, c.WaitForReady))
+			}
+			return nil, nil, err
+		}
+		log.Printf("[DEBUG] Connection attempt %d failed, retrying in %s: %s", attempt, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, nil, errors.Wrap(ctx.Err(), fmt.Sprintf("Gave up connecting after %d attempts", attempt))
+		}
+		backoff *= 2
+		if backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+}
+
+// runsAsRoot tells whether commands run as root, in which case they don't need sudo.
+func (c *Config) runsAsRoot() bool {
+	switch c.ConnectionType {
+	case connectionTypeLocal:
+		return os.Geteuid() == 0
+	case connectionTypeContainer, connectionTypeChroot:
+		return true
+	}
+	return c.User == "root"
+}
+
+// Client returns a client for the host. SSH connections aren't made until the first command needs them,
+// so that the host can be unknown or not up yet while the provider is configured.
+func (c *Config) Client() (*Client, error) {
+	if err := c.Escalation.validate(); err != nil {
+		return nil, err
+	}
+	if c.Escalation.Method == escalationSu && !c.runsAsRoot() {
+		// su reads passwords from a terminal, which commands don't have, so it only works for root, which it
+		// doesn't ask. That's enough to run commands as other users with run_as.
+		return nil, fmt.Errorf("The %q escalation method can only be used when connected as root", escalationSu)
+	}
+
+	var executor Executor
+	switch c.ConnectionType {
+	case connectionTypeLocal:
+		executor = &localExecutor{}
+	case connectionTypeContainer:
+		if c.Container == "" {
+			return nil, fmt.Errorf("container must be set when connection_type is %q", connectionTypeContainer)
+		}
+		executor = &containerExecutor{runtime: c.ContainerRuntime, container: c.Container}
+	case connectionTypeChroot:
+		if c.ChrootDirectory == "" {
+			return nil, fmt.Errorf("chroot_directory must be set when connection_type is %q", connectionTypeChroot)
+		}
+		if info, err := os.Stat(c.ChrootDirectory); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("chroot_directory %s is not a directory", c.ChrootDirectory)
+		}
+		executor = &chrootExecutor{root: c.ChrootDirectory}
+	case connectionTypeSSH, "":
+		executor = &sshExecutor{config: c}
+	default:
+		return nil, fmt.Errorf("Unknown connection_type %q", c.ConnectionType)
+	}
 
-	return &Client{
-		connection: connection,
-		useSudo:    c.UseSudo,
-	}, nil
+	var sessions chan struct{}
+	if c.MaxSessions > 0 {
+		sessions = make(chan struct{}, c.MaxSessions)
+	}
+
+	var audit *auditLog
+	if c.AuditLog != "" {
+		var err error
+		if audit, err = openAuditLog(c.AuditLog, c.target()); err != nil {
+			return nil, err
+		}
+	}
+
+	var script *scriptExport
+	if c.ScriptExport != "" {
+		var err error
+		if script, err = openScriptExport(c.ScriptExport); err != nil {
+			return nil, err
+		}
+	}
+
+	return (&Client{
+		executor:       executor,
+		useSudo:        c.UseSudo,
+		escalation:     c.Escalation,
+		fileTransfer:   c.FileTransfer,
+		sessions:       sessions,
+		audit:          audit,
+		script:         script,
+		readOnly:       c.ReadOnly,
+		protectedPaths: c.ProtectedPaths,
+		managedPaths:   c.ManagedPaths,
+		cachedFacts:    &factsCache{},
+		environment:    defaultEnvironment,
+	}).withEnvironment(c.Environment), nil
+}
+
+// target describes the host that commands run on, as it's recorded in the audit log.
+func (c *Config) target() string {
+	switch c.ConnectionType {
+	case connectionTypeLocal:
+		return "localhost"
+	case connectionTypeContainer:
+		return fmt.Sprintf("%s:%s", c.ContainerRuntime, c.Container)
+	case connectionTypeChroot:
+		return fmt.Sprintf("chroot:%s", c.ChrootDirectory)
+	}
+	return c.Host
 }
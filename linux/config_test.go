@@ -0,0 +1,289 @@
+package linux
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+func generatePrivateKeyPEM(t *testing.T, passphrase string) string {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate key: %v", err)
+	}
+	var block *pem.Block
+	if passphrase != "" {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte(passphrase))
+	} else {
+		block, err = ssh.MarshalPrivateKey(priv, "")
+	}
+	if err != nil {
+		t.Fatalf("Unable to marshal key: %v", err)
+	}
+	return string(pem.EncodeToMemory(block))
+}
+
+func TestAuthMethodsOrder(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyFile, []byte(generatePrivateKeyPEM(t, "")), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := Config{
+		Password:      "secret",
+		PrivateKey:    keyFile,
+		PrivateKeyPEM: generatePrivateKeyPEM(t, ""),
+	}
+	auths, tried, err := config.authMethods()
+	if err != nil {
+		t.Fatalf("Unable to create auth methods: %v", err)
+	}
+	if len(auths) != 3 {
+		t.Fatalf("Keys and password should be offered together, got %d methods", len(auths))
+	}
+	expected := "publickey (private_key_pem, " + keyFile + "), password, keyboard-interactive"
+	if strings.Join(tried, ", ") != expected {
+		t.Errorf("Auth methods should be %q, got %q", expected, strings.Join(tried, ", "))
+	}
+}
+
+func TestAuthMethodsEncryptedKey(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	encrypted := generatePrivateKeyPEM(t, "hunter2")
+
+	config := Config{PrivateKeyPEM: encrypted}
+	if _, _, err := config.authMethods(); err == nil || !strings.Contains(err.Error(), "private_key_passphrase") {
+		t.Errorf("Encrypted key without passphrase should ask for private_key_passphrase: %v", err)
+	}
+
+	config.PrivateKeyPassphrase = "wrong"
+	if _, _, err := config.authMethods(); err == nil {
+		t.Errorf("Encrypted key with the wrong passphrase should be rejected")
+	}
+
+	config.PrivateKeyPassphrase = "hunter2"
+	auths, _, err := config.authMethods()
+	if err != nil || len(auths) != 1 {
+		t.Errorf("Encrypted key with the passphrase should be usable: %v", err)
+	}
+}
+
+func TestAuthMethodsMissingKeyFile(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	config := Config{PrivateKey: "/nonexistent/.ssh/id_rsa", Password: "secret"}
+	if _, tried, err := config.authMethods(); err != nil || strings.Join(tried, ", ") != "password, keyboard-interactive" {
+		t.Errorf("Missing default key should be skipped: %v %v", tried, err)
+	}
+
+	config.PrivateKey = "/nonexistent/key"
+	if _, _, err := config.authMethods(); err == nil {
+		t.Errorf("Missing explicitly configured key should be an error")
+	}
+}
+
+func TestCertificateAuthentication(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyPEM := generatePrivateKeyPEM(t, "")
+	key, err := ssh.ParsePrivateKey([]byte(keyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &ssh.Certificate{
+		Key:             key.PublicKey(),
+		CertType:        ssh.UserCert,
+		KeyId:           "deploy",
+		ValidPrincipals: []string{"deploy"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+
+	// The equivalent of an sshd with TrustedUserCAKeys set to the CA's public key.
+	checker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return bytes.Equal(auth.Marshal(), ca.PublicKey().Marshal())
+		},
+	}
+	server := startTestSSHServer(t, &ssh.ServerConfig{PublicKeyCallback: checker.Authenticate})
+
+	config := Config{Host: "127.0.0.1", Port: server.port, User: "deploy", PrivateKeyPEM: keyPEM, HostKeys: []string{server.hostKey}}
+	if _, _, err := config.connect(context.Background()); err == nil || !strings.Contains(err.Error(), "tried auth methods: publickey (private_key_pem)") {
+		t.Errorf("Plain key should be rejected and reported: %v", err)
+	}
+
+	certFile := filepath.Join(t.TempDir(), "id_ed25519-cert.pub")
+	if err := os.WriteFile(certFile, ssh.MarshalAuthorizedKey(cert), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, certificate := range []string{string(ssh.MarshalAuthorizedKey(cert)), certFile} {
+		config.Certificate = certificate
+		connection, _, err := config.connect(context.Background())
+		if err != nil {
+			t.Errorf("Certificate should be accepted: %v", err)
+			continue
+		}
+		connection.Close()
+	}
+}
+
+func TestCertificateWithoutMatchingKey(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	other, err := ssh.ParsePrivateKey([]byte(generatePrivateKeyPEM(t, "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &ssh.Certificate{Key: other.PublicKey(), CertType: ssh.UserCert, ValidBefore: ssh.CertTimeInfinity}
+	if err := cert.SignCert(rand.Reader, other); err != nil {
+		t.Fatal(err)
+	}
+
+	config := Config{PrivateKeyPEM: generatePrivateKeyPEM(t, ""), Certificate: string(ssh.MarshalAuthorizedKey(cert))}
+	if _, _, err := config.authMethods(); err == nil {
+		t.Errorf("Certificate for another key should be rejected")
+	}
+}
+
+func TestKeyboardInteractiveAuthentication(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	server := startTestSSHServer(t, &ssh.ServerConfig{
+		KeyboardInteractiveCallback: func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := client("", "", []string{"Password: ", "Verification code: "}, []bool{false, false})
+			if err != nil {
+				return nil, err
+			}
+			if answers[0] != "secret" || answers[1] != "123456" {
+				return nil, fmt.Errorf("wrong answers")
+			}
+			return nil, nil
+		},
+	})
+
+	config := Config{Host: "127.0.0.1", Port: server.port, User: "admin", Password: "secret", HostKeys: []string{server.hostKey}}
+	if _, _, err := config.connect(context.Background()); err == nil {
+		t.Errorf("Unanswered prompt should fail authentication")
+	}
+
+	config.KeyboardInteractive = []KeyboardInteractiveAnswer{
+		{Prompt: regexp.MustCompile(`(?i)verification code`), Answer: "123456"},
+	}
+	connection, _, err := config.connect(context.Background())
+	if err != nil {
+		t.Fatalf("Keyboard-interactive prompts should be answered: %v", err)
+	}
+	connection.Close()
+}
+
+func TestClientConnectsLazily(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	config := Config{Host: "127.0.0.1", Port: 1, User: "admin", Password: "secret", ConnectTimeout: time.Second}
+	client, err := config.Client()
+	if err != nil {
+		t.Fatalf("Client shouldn't dial until it's used: %v", err)
+	}
+	if _, err := client.executor.(*sshExecutor).connect(context.Background()); err == nil {
+		t.Errorf("Connecting to a closed port should fail")
+	}
+}
+
+func TestConnectWaitsForReady(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	serverConfig := &ssh.ServerConfig{PasswordCallback: acceptAnyPassword}
+	// Bring the server up on the same port after the first attempts have failed.
+	time.AfterFunc(1500*time.Millisecond, func() {
+		startTestSSHServerOnPort(t, serverConfig, port)
+	})
+
+	config := Config{
+		Host:           "127.0.0.1",
+		Port:           port,
+		User:           "admin",
+		Password:       "secret",
+		ConnectTimeout: time.Second,
+		// The server isn't up yet, so its host key isn't known.
+		InsecureIgnoreHostKey: true,
+		WaitForReady:          20 * time.Second,
+	}
+	connection, _, err := config.connect(context.Background())
+	if err != nil {
+		t.Fatalf("Connection should be retried until the host is up: %v", err)
+	}
+	connection.Close()
+}
+
+func TestConnectHonorsContext(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	config := Config{
+		Host:           "127.0.0.1",
+		Port:           1,
+		User:           "admin",
+		Password:       "secret",
+		ConnectTimeout: time.Second,
+		// Nothing listens on the port, so there is no host key to pin.
+		InsecureIgnoreHostKey: true,
+		WaitForReady:          time.Minute,
+	}
+	client, err := config.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	executor := client.executor.(*sshExecutor)
+
+	// One caller dials, while the other waits for it. Both should give up with their ctx, instead of
+	// waiting for the host as long as wait_for_ready allows.
+	start := time.Now()
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+			_, err := executor.connect(ctx)
+			errs <- err
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Connecting should give up when the context is done: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Connecting took %s after the context was done", elapsed)
+	}
+
+	executor.mutex.Lock()
+	defer executor.mutex.Unlock()
+	if executor.connectErr != nil || executor.dialing != nil {
+		t.Errorf("A dial cut short by its context shouldn't be kept: %v", executor.connectErr)
+	}
+}
@@ -0,0 +1,96 @@
+package linux
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// containerExecutor runs commands inside a running container, through the docker or podman CLI.
+type containerExecutor struct {
+	runtime   string
+	container string
+}
+
+// containerPIDPrefix starts the line that the shell in the container writes its PID on, to stderr, before it
+// execs the command, which keeps the PID.
+const containerPIDPrefix = "[terraform-provider-linux] pid "
+
+func (e *containerExecutor) Execute(ctx context.Context, command string, stdin io.Reader, stdout io.Writer, stderr io.Writer) (int, error) {
+	script := fmt.Sprintf(`echo "%s$$" >&2; exec /bin/sh -c "$1"`, containerPIDPrefix)
+	// Commands run as root whatever the image's USER is, which is what use_sudo's default assumes.
+	cmd := exec.CommandContext(ctx, e.runtime, "exec", "-i", "--user", "0", e.container, "/bin/sh", "-c", script, "sh", command)
+
+	// docker and podman exec don't pass signals on, so stopping the CLI leaves the command running. It's sent
+	// SIGTERM inside the container instead, like sshd does with the command of a session.
+	pids := &containerPIDWriter{stderr: stderr, pids: make(chan int, 1)}
+	stop := context.AfterFunc(ctx, func() {
+		if pid, ok := <-pids.pids; ok {
+			e.kill(pid)
+		}
+	})
+	status, err := runLocal(ctx, cmd, stdin, stdout, pids)
+	stop()
+	pids.close()
+	return status, err
+}
+
+// kill sends SIGTERM to the process pid in the container.
+func (e *containerExecutor) kill(pid int) {
+	ctx, cancel := context.WithTimeout(context.Background(), localKillDelay)
+	defer cancel()
+	// kill is run as the shell's builtin, since slim images often lack the binary.
+	kill := exec.CommandContext(ctx, e.runtime, "exec", "--user", "0", e.container, "/bin/sh", "-c", `kill -TERM "$1"`, "sh", strconv.Itoa(pid))
+	if output, err := kill.CombinedOutput(); err != nil {
+		log.Printf("[WARN] Unable to stop process %d in container %s: %v: %s", pid, e.container, err, output)
+	}
+}
+
+// containerPIDWriter writes to stderr what the command writes to it, except for the line with the PID of the
+// shell, which is sent to pids instead.
+type containerPIDWriter struct {
+	stderr io.Writer
+	pids   chan int
+	line   []byte
+	read   bool
+}
+
+func (w *containerPIDWriter) Write(p []byte) (int, error) {
+	if w.read {
+		return w.stderr.Write(p)
+	}
+	w.line = append(w.line, p...)
+	i := bytes.IndexByte(w.line, '\n')
+	if i < 0 {
+		return len(p), nil
+	}
+	w.read = true
+	rest := w.line
+	if line := string(w.line[:i]); strings.HasPrefix(line, containerPIDPrefix) {
+		if pid, err := strconv.Atoi(strings.TrimPrefix(line, containerPIDPrefix)); err == nil {
+			w.pids <- pid
+			rest = w.line[i+1:]
+		}
+	}
+	w.line = nil
+	if len(rest) > 0 {
+		if _, err := w.stderr.Write(rest); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// close writes what's left of an incomplete first line, such as an error of the runtime, once the command
+// is done.
+func (w *containerPIDWriter) close() {
+	if len(w.line) > 0 {
+		w.stderr.Write(w.line)
+	}
+	close(w.pids)
+}
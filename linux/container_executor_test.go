@@ -0,0 +1,68 @@
+package linux
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// testContainerRuntime returns a stand-in for the docker CLI, which checks the exec arguments and runs the
+// command locally. Like docker, it doesn't pass signals on to the command.
+func testContainerRuntime(t *testing.T) string {
+	runtime := filepath.Join(t.TempDir(), "docker")
+	script := `#!/bin/sh
+[ "$1" = exec ] || exit 125
+shift
+[ "$1" = -i ] && shift
+[ "$1 $2 $3" = "--user 0 testcontainer" ] || exit 125
+shift 3
+exec setsid -w "$@"
+`
+	if err := os.WriteFile(runtime, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return runtime
+}
+
+func TestContainerExecutor(t *testing.T) {
+	executor := &containerExecutor{runtime: testContainerRuntime(t), container: "testcontainer"}
+
+	var stdout, stderr bytes.Buffer
+	status, err := executor.Execute(context.Background(), "cat > /dev/null; echo \"it's here\"; echo oops >&2; exit 3", strings.NewReader("content"), &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("Command should run: %v", err)
+	}
+	if status != 3 || stdout.String() != "it's here\n" || stderr.String() != "oops\n" {
+		t.Errorf("Unexpected result: status %d, stdout %q, stderr %q", status, stdout.String(), stderr.String())
+	}
+}
+
+func TestContainerExecutorCancelled(t *testing.T) {
+	executor := &containerExecutor{runtime: testContainerRuntime(t), container: "testcontainer"}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	marker := filepath.Join(t.TempDir(), "marker")
+	var stdout, stderr bytes.Buffer
+	command := "sleep 1; touch " + shellQuote(marker)
+	if _, err := executor.Execute(ctx, command, strings.NewReader(""), &stdout, &stderr); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expired context should stop the command: %v", err)
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if _, err := os.Stat(marker); err == nil {
+		t.Errorf("Command should be stopped in the container, not only the runtime's CLI")
+	}
+}
+
+func TestContainerRequiresName(t *testing.T) {
+	config := Config{ConnectionType: connectionTypeContainer, ContainerRuntime: "docker"}
+	if _, err := config.Client(); err == nil {
+		t.Errorf("Container connection without a container should be rejected")
+	}
+}
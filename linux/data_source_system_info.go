@@ -0,0 +1,55 @@
+package linux
+
+import (
+	"context"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func systemInfoDataSource() *schema.Resource {
+	computed := func(t schema.ValueType, description string) *schema.Schema {
+		return &schema.Schema{Type: t, Computed: true, Description: description}
+	}
+	return &schema.Resource{
+		ReadContext: systemInfoDataSourceRead,
+
+		Schema: map[string]*schema.Schema{
+			"kernel":          computed(schema.TypeString, "The kernel release, as uname -r prints it"),
+			"arch":            computed(schema.TypeString, "The machine architecture, as uname -m prints it"),
+			"hostname":        computed(schema.TypeString, "The hostname"),
+			"distro_id":       computed(schema.TypeString, "The ID from /etc/os-release, such as debian or alpine"),
+			"distro_version":  computed(schema.TypeString, "The VERSION_ID from /etc/os-release"),
+			"cpus":            computed(schema.TypeInt, "The number of CPUs available"),
+			"memory_mb":       computed(schema.TypeInt, "The total memory, in MiB"),
+			"init_system":     computed(schema.TypeString, "The init system, such as systemd or openrc"),
+			"package_manager": computed(schema.TypeString, "The package manager, such as apt, dnf or apk"),
+			"coreutils":       computed(schema.TypeString, "The flavour of the basic commands: gnu, busybox or other"),
+			"sudo":            computed(schema.TypeBool, "Whether sudo is installed"),
+			"selinux":         computed(schema.TypeString, "The SELinux mode: enforcing, permissive or disabled"),
+		},
+	}
+}
+
+func systemInfoDataSourceRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	client := m.(*Client)
+	facts, err := client.facts(ctx)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	d.SetId(facts.Hostname)
+	d.Set("kernel", facts.Kernel)
+	d.Set("arch", facts.Arch)
+	d.Set("hostname", facts.Hostname)
+	d.Set("distro_id", facts.DistroID)
+	d.Set("distro_version", facts.DistroVersion)
+	d.Set("cpus", facts.CPUs)
+	d.Set("memory_mb", facts.MemoryMB)
+	d.Set("init_system", facts.InitSystem)
+	d.Set("package_manager", facts.PackageManager)
+	d.Set("coreutils", facts.Coreutils)
+	d.Set("sudo", facts.Sudo)
+	d.Set("selinux", facts.SELinux)
+	return nil
+}
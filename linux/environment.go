@@ -0,0 +1,123 @@
+package linux
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// defaultEnvironment is the environment every command runs with, unless it's overridden. The parsers of the
+// output of commands such as ls and id expect the C locale, and useradd and the like are in /usr/sbin, which
+// isn't in the PATH of every login user.
+var defaultEnvironment = map[string]string{
+	"LC_ALL": "C",
+	"PATH":   "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+}
+
+var environmentName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func environmentSchema(description string) *schema.Schema {
+	return &schema.Schema{
+		Type:         schema.TypeMap,
+		Optional:     true,
+		Elem:         &schema.Schema{Type: schema.TypeString},
+		ValidateFunc: validateEnvironment,
+		Description:  description,
+	}
+}
+
+func validateEnvironment(vi interface{}, k string) (ws []string, errors []error) {
+	for name := range vi.(map[string]interface{}) {
+		if !environmentName.MatchString(name) {
+			errors = append(errors, fmt.Errorf("%s: %q isn't a valid environment variable name", k, name))
+		}
+	}
+	return
+}
+
+func expandEnvironment(m map[string]interface{}) map[string]string {
+	environment := make(map[string]string, len(m))
+	for name, value := range m {
+		environment[name] = value.(string)
+	}
+	return environment
+}
+
+// withEnvironment returns a client that runs commands with environment on top of its own.
+func (c *Client) withEnvironment(environment map[string]string) *Client {
+	if len(environment) == 0 {
+		return c
+	}
+	client := *c
+	client.environment = make(map[string]string, len(c.environment)+len(environment))
+	for name, value := range c.environment {
+		client.environment[name] = value
+	}
+	for name, value := range environment {
+		client.environment[name] = value
+	}
+	return &client
+}
+
+// inEnvironment returns command, set to run with the client's environment. The variables are exported before
+// the command, so that they also apply to commands joined with && and ||. Escalated commands are given the
+// environment inside the escalation instead, see Escalation.command.
+func (c *Client) inEnvironment(command string) string {
+	return exportEnvironment(c.environment) + command
+}
+
+// exportEnvironment returns the shell command that exports environment, followed by "; ", or nothing if
+// it's empty.
+func exportEnvironment(environment map[string]string) string {
+	if len(environment) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(environment))
+	for name := range environment {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	assignments := make([]string, len(names))
+	for i, name := range names {
+		assignments[i] = fmt.Sprintf("%s=%s", name, shellQuote(environment[name]))
+	}
+	return fmt.Sprintf("export %s; ", strings.Join(assignments, " "))
+}
+
+// isDefaultEnvironment tells whether environment is defaultEnvironment, which escalated commands can be
+// given without a shell.
+func isDefaultEnvironment(environment map[string]string) bool {
+	if len(environment) != len(defaultEnvironment) {
+		return false
+	}
+	for name, value := range defaultEnvironment {
+		if v, ok := environment[name]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// environmentResource makes the CRUD functions of resource run their commands with the resource's
+// environment, on top of the provider's.
+func environmentResource(resource *schema.Resource) *schema.Resource {
+	wrap := func(f func(context.Context, *schema.ResourceData, interface{}) diag.Diagnostics) func(context.Context, *schema.ResourceData, interface{}) diag.Diagnostics {
+		if f == nil {
+			return nil
+		}
+		return func(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+			environment := expandEnvironment(d.Get("environment").(map[string]interface{}))
+			return f(ctx, d, m.(*Client).withEnvironment(environment))
+		}
+	}
+	resource.CreateContext = wrap(resource.CreateContext)
+	resource.ReadContext = wrap(resource.ReadContext)
+	resource.UpdateContext = wrap(resource.UpdateContext)
+	resource.DeleteContext = wrap(resource.DeleteContext)
+	return resource
+}
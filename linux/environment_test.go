@@ -0,0 +1,82 @@
+package linux
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestCommandEnvironment(t *testing.T) {
+	config := Config{ConnectionType: connectionTypeLocal, Environment: map[string]string{"GREETING": "it's here"}}
+	client, err := config.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, _, err := runCommand(context.Background(), client, false, `true && echo "$LC_ALL|$PATH|$GREETING"`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "C|" + defaultEnvironment["PATH"] + "|it's here\n"; stdout != expected {
+		t.Errorf("Commands should run with the environment: %q, expected %q", stdout, expected)
+	}
+}
+
+func TestResourceEnvironment(t *testing.T) {
+	client, executor := testFakeClient(nil)
+	client = client.withEnvironment(map[string]string{"LC_ALL": "C", "HTTPS_PROXY": "http://proxy:3128"})
+	client.useSudo = true
+
+	file := resources()["linux_file"]
+	d := schema.TestResourceDataRaw(t, file.Schema, map[string]interface{}{
+		"path":        "/etc/motd",
+		"environment": map[string]interface{}{"HTTPS_PROXY": "http://other:3128", "TOOL_OPTS": "-v -x"},
+	})
+	d.SetId("/etc/motd")
+	if diags := file.DeleteContext(context.Background(), d, client); diags.HasError() {
+		t.Fatalf("Unable to delete file: %v", diags)
+	}
+	assertCommands(t, executor, "export HTTPS_PROXY=http://other:3128 LC_ALL=C TOOL_OPTS='-v -x'; rm -rf /etc/motd")
+
+	if len(client.environment) != 2 || client.environment["HTTPS_PROXY"] != "http://proxy:3128" {
+		t.Errorf("The provider's environment should be left as it is: %v", client.environment)
+	}
+}
+
+func TestEnvironmentInsideEscalation(t *testing.T) {
+	client, executor := testFakeClient(nil)
+	client = client.withEnvironment(map[string]string{"LC_ALL": "C", "TOOL_OPTS": "-v -x"})
+	client.useSudo = true
+
+	runCommand(context.Background(), client, true, "chmod 644 /etc/motd", "")
+	runCommand(context.Background(), client.as("svc"), false, "ls -ld /home/svc", "")
+	runCommand(context.Background(), client, false, "cat /etc/motd", "")
+	assertCommands(t, executor,
+		`sudo sh -c 'export LC_ALL=C TOOL_OPTS='\''-v -x'\''; chmod 644 /etc/motd'`,
+		`sudo -u svc sh -c 'export LC_ALL=C TOOL_OPTS='\''-v -x'\''; ls -ld /home/svc'`,
+		"export LC_ALL=C TOOL_OPTS='-v -x'; cat /etc/motd",
+	)
+}
+
+func TestDefaultEnvironmentOutsideShell(t *testing.T) {
+	client, executor := testFakeClient(nil)
+	client = client.withEnvironment(defaultEnvironment)
+	client.useSudo = true
+
+	runCommand(context.Background(), client, true, "chmod 644 /etc/motd", "")
+	runCommand(context.Background(), client, false, "cat /etc/motd", "")
+	assertCommands(t, executor,
+		"sudo LC_ALL=C chmod 644 /etc/motd",
+		"export LC_ALL=C PATH="+defaultEnvironment["PATH"]+"; cat /etc/motd",
+	)
+}
+
+func TestValidateEnvironment(t *testing.T) {
+	if _, errs := validateEnvironment(map[string]interface{}{"HTTP_PROXY": "", "_x1": ""}, "environment"); len(errs) > 0 {
+		t.Errorf("Valid names should be accepted: %v", errs)
+	}
+	if _, errs := validateEnvironment(map[string]interface{}{"1X": "", "A-B": "", "A;rm -rf /": ""}, "environment"); len(errs) != 3 {
+		t.Errorf("Invalid names should be rejected: %v", errs)
+	}
+}
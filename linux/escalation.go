@@ -0,0 +1,181 @@
+package linux
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+const (
+	escalationSudo = "sudo"
+	escalationDoas = "doas"
+	escalationSu   = "su"
+	escalationRun0 = "run0"
+	escalationNone = "none"
+)
+
+// The prompt sudo is told to print when it wants become_password, and the line printed once the command
+// starts. Both are removed from the command's stderr.
+const (
+	becomePrompt = "[terraform-provider-linux] become password: "
+	becomeReady  = "[terraform-provider-linux] become ready"
+)
+
+// Escalation is how commands that need root privileges get them.
+type Escalation struct {
+	Method   string
+	Password string
+	Flags    []string
+}
+
+func (e Escalation) validate() error {
+	switch e.Method {
+	case escalationSudo, "":
+	case escalationDoas, escalationSu, escalationRun0, escalationNone:
+		if e.Password != "" {
+			// These read the password from a terminal, which commands don't have.
+			return fmt.Errorf("become_password is only supported with the %q escalation method", escalationSudo)
+		}
+	default:
+		return fmt.Errorf("Unknown escalation method %q", e.Method)
+	}
+	return nil
+}
+
+// command returns command wrapped to run as user, or with root privileges if user is empty, with environment.
+func (e Escalation) command(user string, environment map[string]string, command string) string {
+	flags := ""
+	if len(e.Flags) > 0 {
+		flags = shellCommand(e.Flags...) + " "
+	}
+	script := exportEnvironment(environment) + command
+	if e.Method == escalationSu {
+		if user == "" {
+			user = "root"
+		}
+		// The command is run with sh rather than the user's login shell, which service accounts usually don't
+		// have, e.g. /usr/sbin/nologin.
+		return fmt.Sprintf("su -s /bin/sh %s%s -c %s", flags, shellQuote(user), shellQuote(script))
+	}
+	if e.Method == escalationNone {
+		return script
+	}
+
+	// Commands run as a user go through a shell, so that their redirections are made as that user too. So do
+	// commands with an environment other than the default one, which the shell exports. The default one is
+	// left to the escalation, which sets LC_ALL itself and its own PATH, such as sudo's secure_path, so that
+	// the escalated command is still the one that sudoers rules grant.
+	shell := user != "" || (len(environment) > 0 && !isDefaultEnvironment(environment))
+	if shell {
+		command = fmt.Sprintf("sh -c %s", shellQuote(script))
+	}
+	target := ""
+	if user != "" {
+		if e.Method == escalationRun0 {
+			target = fmt.Sprintf("--user=%s ", shellQuote(user))
+		} else {
+			target = fmt.Sprintf("-u %s ", shellQuote(user))
+		}
+	}
+	locale, setLocale := environment["LC_ALL"]
+	setLocale = setLocale && !shell
+
+	switch e.Method {
+	case escalationDoas:
+		// doas can't set variables for the command, so LC_ALL is left to doas.conf's setenv.
+		return fmt.Sprintf("doas %s%s%s", flags, target, command)
+	case escalationRun0:
+		if setLocale {
+			return fmt.Sprintf("run0 %s%s %s", flags, shellQuote("--setenv=LC_ALL="+locale), command)
+		}
+		return fmt.Sprintf("run0 %s%s%s", flags, target, command)
+	}
+	if e.Password != "" {
+		// The command announces itself on stderr, so that its stdin isn't sent before sudo is done reading
+		// the password, and the password isn't sent at all if sudo doesn't ask for it. It runs in a shell
+		// already, which the environment can be exported in too.
+		if user == "" {
+			command = script
+		}
+		wrapped := fmt.Sprintf("echo %s >&2; %s", shellQuote(becomeReady), command)
+		return fmt.Sprintf("sudo -S -p %s %s%ssh -c %s", shellQuote(becomePrompt), flags, target, shellQuote(wrapped))
+	}
+	if setLocale {
+		// Like the other LC_ variables, sudo's default env_check lets it through.
+		return fmt.Sprintf("sudo %sLC_ALL=%s %s", flags, shellQuote(locale), command)
+	}
+	return fmt.Sprintf("sudo %s%s%s", flags, target, command)
+}
+
+// becomeSession feeds a command run through sudo -S its password and then its stdin. Sudo's prompts and the
+// command's ready line are detected on stderr, which is written to it.
+type becomeSession struct {
+	password string
+	content  string
+
+	stdin      *io.PipeReader
+	stdinInput *io.PipeWriter
+
+	mutex    sync.Mutex
+	stderr   cappedBuffer
+	prompts  int
+	started  bool
+	rejected bool
+}
+
+func newBecomeSession(password string, content string) *becomeSession {
+	r, w := io.Pipe()
+	return &becomeSession{
+		password:   password,
+		content:    content,
+		stdin:      r,
+		stdinInput: w,
+		stderr:     cappedBuffer{max: maxCommandStderr},
+	}
+}
+
+func (s *becomeSession) Write(p []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.stderr.Write(p)
+
+	if s.started {
+		return len(p), nil
+	}
+	if prompts := bytes.Count(s.stderr.buffer.Bytes(), []byte(becomePrompt)); prompts > s.prompts {
+		s.prompts = prompts
+		if prompts > 1 {
+			// Sudo asks again when the password is wrong. Closing stdin makes it give up.
+			s.rejected = true
+			s.stdinInput.Close()
+			return len(p), nil
+		}
+		go io.WriteString(s.stdinInput, s.password+"\n")
+	}
+	if bytes.Contains(s.stderr.buffer.Bytes(), []byte(becomeReady+"\n")) {
+		s.started = true
+		go func() {
+			io.WriteString(s.stdinInput, s.content)
+			s.stdinInput.Close()
+		}()
+	}
+	return len(p), nil
+}
+
+// close unblocks anything still feeding the command, once it has exited.
+func (s *becomeSession) close() {
+	s.stdin.Close()
+	s.stdinInput.Close()
+}
+
+// output returns the command's stderr without the prompts and the ready line, and whether the password
+// was rejected.
+func (s *becomeSession) output() (string, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stderr := strings.Replace(s.stderr.String(), becomePrompt, "", -1)
+	stderr = strings.Replace(stderr, becomeReady+"\n", "", 1)
+	return stderr, s.rejected
+}
@@ -0,0 +1,182 @@
+package linux
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEscalationCommand(t *testing.T) {
+	cases := []struct {
+		escalation Escalation
+		expected   string
+	}{
+		{Escalation{Method: escalationSudo}, "sudo chmod 644 /etc/testfile"},
+		{Escalation{Method: escalationSudo, Flags: []string{"-H", "-n"}}, "sudo -H -n chmod 644 /etc/testfile"},
+		{Escalation{Method: escalationDoas, Flags: []string{"-n"}}, "doas -n chmod 644 /etc/testfile"},
+		{Escalation{Method: escalationRun0}, "run0 chmod 644 /etc/testfile"},
+		{Escalation{Method: escalationRun0, Flags: []string{"--description=terraform apply", "--setenv=A=$(id)"}}, "run0 '--description=terraform apply' '--setenv=A=$(id)' chmod 644 /etc/testfile"},
+		{Escalation{Method: escalationSu, Flags: []string{"-l"}}, "su -s /bin/sh -l root -c 'chmod 644 /etc/testfile'"},
+		{Escalation{Method: escalationNone}, "chmod 644 /etc/testfile"},
+		{
+			Escalation{Method: escalationSudo, Password: "secret"},
+			"sudo -S -p '" + becomePrompt + "' sh -c 'echo '\\''" + becomeReady + "'\\'' >&2; chmod 644 /etc/testfile'",
+		},
+	}
+	for _, c := range cases {
+		if command := c.escalation.command("", nil, "chmod 644 /etc/testfile"); command != c.expected {
+			t.Errorf("%s escalation should give %q, got %q", c.escalation.Method, c.expected, command)
+		}
+	}
+}
+
+func TestEscalationCommandAsUser(t *testing.T) {
+	cases := []struct {
+		escalation Escalation
+		expected   string
+	}{
+		{Escalation{Method: escalationSudo}, "sudo -u svc sh -c 'cat > /home/svc/file'"},
+		{Escalation{Method: escalationDoas}, "doas -u svc sh -c 'cat > /home/svc/file'"},
+		{Escalation{Method: escalationRun0}, "run0 --user=svc sh -c 'cat > /home/svc/file'"},
+		// Service accounts usually have nologin as their shell, so su is told to use sh.
+		{Escalation{Method: escalationSu}, "su -s /bin/sh svc -c 'cat > /home/svc/file'"},
+	}
+	for _, c := range cases {
+		if command := c.escalation.command("svc", nil, "cat > /home/svc/file"); command != c.expected {
+			t.Errorf("%s escalation should give %q, got %q", c.escalation.Method, c.expected, command)
+		}
+	}
+}
+
+func TestEscalationCommandWithExports(t *testing.T) {
+	cases := []struct {
+		escalation Escalation
+		user       string
+		expected   string
+	}{
+		{Escalation{Method: escalationSudo}, "", "sudo sh -c 'export LC_ALL=C; ls -ld /etc'"},
+		{Escalation{Method: escalationSudo}, "svc", "sudo -u svc sh -c 'export LC_ALL=C; ls -ld /etc'"},
+		{Escalation{Method: escalationDoas}, "", "doas sh -c 'export LC_ALL=C; ls -ld /etc'"},
+		{Escalation{Method: escalationRun0}, "svc", "run0 --user=svc sh -c 'export LC_ALL=C; ls -ld /etc'"},
+		{Escalation{Method: escalationSu}, "", "su -s /bin/sh root -c 'export LC_ALL=C; ls -ld /etc'"},
+		{
+			Escalation{Method: escalationSudo, Password: "secret"}, "",
+			"sudo -S -p '" + becomePrompt + "' sh -c 'echo '\\''" + becomeReady + "'\\'' >&2; export LC_ALL=C; ls -ld /etc'",
+		},
+	}
+	for _, c := range cases {
+		if command := c.escalation.command(c.user, map[string]string{"LC_ALL": "C"}, "ls -ld /etc"); command != c.expected {
+			t.Errorf("%s escalation should give %q, got %q", c.escalation.Method, c.expected, command)
+		}
+	}
+}
+
+func TestEscalationCommandWithDefaultEnvironment(t *testing.T) {
+	// The escalated command stays the one sudoers and doas.conf rules grant, such as /usr/sbin/useradd.
+	cases := []struct {
+		escalation Escalation
+		user       string
+		expected   string
+	}{
+		{Escalation{Method: escalationSudo}, "", "sudo LC_ALL=C useradd -- svc"},
+		{Escalation{Method: escalationSudo, Flags: []string{"-n"}}, "", "sudo -n LC_ALL=C useradd -- svc"},
+		{Escalation{Method: escalationDoas}, "", "doas useradd -- svc"},
+		{Escalation{Method: escalationRun0}, "", "run0 --setenv=LC_ALL=C useradd -- svc"},
+		{
+			Escalation{Method: escalationSudo}, "svc",
+			"sudo -u svc sh -c 'export LC_ALL=C PATH=" + defaultEnvironment["PATH"] + "; useradd -- svc'",
+		},
+	}
+	for _, c := range cases {
+		if command := c.escalation.command(c.user, defaultEnvironment, "useradd -- svc"); command != c.expected {
+			t.Errorf("%s escalation should give %q, got %q", c.escalation.Method, c.expected, command)
+		}
+	}
+}
+
+func TestEscalationPasswordOnlyWithSudo(t *testing.T) {
+	for _, method := range []string{escalationDoas, escalationSu, escalationRun0} {
+		config := Config{ConnectionType: connectionTypeLocal, Escalation: Escalation{Method: method, Password: "secret"}}
+		if _, err := config.Client(); err == nil {
+			t.Errorf("become_password should be rejected with %s", method)
+		}
+	}
+}
+
+func TestSuOnlyAsRoot(t *testing.T) {
+	config := Config{Host: "example.com", User: "admin", Escalation: Escalation{Method: escalationSu}}
+	if _, err := config.Client(); err == nil {
+		t.Errorf("su should be rejected when not connected as root")
+	}
+	config.User = "root"
+	if _, err := config.Client(); err != nil {
+		t.Errorf("su should be accepted when connected as root: %v", err)
+	}
+}
+
+// testBecomeClient returns a local client whose sudo is a script that asks for the password "secret",
+// unless SUDO_NOPASSWD is set.
+func testBecomeClient(t *testing.T, password string) *Client {
+	bin := t.TempDir()
+	script := `#!/bin/sh
+[ "$1 $2" = "-S -p" ] || exit 1
+prompt=$3
+shift 3
+if [ -z "$SUDO_NOPASSWD" ]; then
+  printf '%s' "$prompt" >&2
+  read password
+  if [ "$password" != secret ]; then
+    echo "Sorry, try again." >&2
+    printf '%s' "$prompt" >&2
+    read password || exit 1
+    [ "$password" = secret ] || exit 1
+  fi
+fi
+exec "$@"
+`
+	if err := os.WriteFile(filepath.Join(bin, "sudo"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	config := Config{
+		ConnectionType: connectionTypeLocal,
+		UseSudo:        true,
+		Escalation:     Escalation{Method: escalationSudo, Password: password},
+	}
+	// The environment is only exported inside the escalation, so the fake sudo is found with the PATH commands
+	// are started with.
+	t.Setenv("PATH", bin+":"+os.Getenv("PATH"))
+	client, err := config.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestRunCommandWithBecomePassword(t *testing.T) {
+	client := testBecomeClient(t, "secret")
+	stdout, stderr, err := runCommand(context.Background(), client, true, "cat; echo done >&2", "some content")
+	if err != nil {
+		t.Fatalf("Command should run with the password: %v", err)
+	}
+	if stdout != "some content" {
+		t.Errorf("Stdin should be passed to the command after the password: %q", stdout)
+	}
+	if stderr != "done\n" {
+		t.Errorf("Prompt and ready line should be removed from stderr: %q", stderr)
+	}
+
+	t.Setenv("SUDO_NOPASSWD", "1")
+	stdout, _, err = runCommand(context.Background(), client, true, "cat", "some content")
+	if err != nil || stdout != "some content" {
+		t.Errorf("Password shouldn't be sent when sudo doesn't ask for it: %q, %v", stdout, err)
+	}
+}
+
+func TestRunCommandWithWrongBecomePassword(t *testing.T) {
+	client := testBecomeClient(t, "wrong")
+	if _, _, err := runCommand(context.Background(), client, true, "cat", "some content"); err == nil || !strings.Contains(err.Error(), "become_password") {
+		t.Errorf("Rejected password should be reported: %v", err)
+	}
+}
@@ -0,0 +1,14 @@
+package linux
+
+import (
+	"context"
+	"io"
+)
+
+// Executor runs commands on the system managed by the provider.
+type Executor interface {
+	// Execute runs command with stdin as its input, copying its output to stdout and stderr. It returns
+	// the exit status of the command, and only returns an error if the command couldn't be run at all. If ctx
+	// is done before the command exits, the command is signalled to stop and ctx's error is returned.
+	Execute(ctx context.Context, command string, stdin io.Reader, stdout io.Writer, stderr io.Writer) (int, error)
+}
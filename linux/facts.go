@@ -0,0 +1,123 @@
+package linux
+
+import (
+	"bufio"
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// hostFacts describe the system on the host.
+type hostFacts struct {
+	Kernel         string
+	Arch           string
+	Hostname       string
+	DistroID       string
+	DistroVersion  string
+	CPUs           int
+	MemoryMB       int
+	InitSystem     string
+	PackageManager string
+	// Coreutils is the flavour of the basic commands: gnu, busybox or other.
+	Coreutils string
+	Sudo      bool
+	// SELinux is enforcing, permissive or disabled.
+	SELinux string
+}
+
+// factsProbe gathers the facts in a single command. It prints one key=value line for each of them, and
+// leaves the interpretation to parseFacts. It only reads from the host, and works with busybox's sh.
+const factsProbe = `echo "kernel=$(uname -r)"
+echo "arch=$(uname -m)"
+echo "hostname=$(uname -n)"
+sed -n -e 's/^ID=/distro_id=/p' -e 's/^VERSION_ID=/distro_version=/p' /etc/os-release 2>/dev/null
+echo "cpus=$(nproc 2>/dev/null || grep -c ^processor /proc/cpuinfo)"
+sed -n 's/^MemTotal: *\([0-9]*\) kB/memory_kb=\1/p' /proc/meminfo
+echo "init=$(cat /proc/1/comm 2>/dev/null)"
+[ -d /run/systemd/system ] && echo "systemd=true"
+[ -x /sbin/openrc ] && echo "openrc=true"
+for pm in apt-get dnf yum zypper apk pacman; do
+  command -v $pm >/dev/null 2>&1 && echo "package_manager=$pm" && break
+done
+ls --version 2>/dev/null | grep -q GNU && echo "coreutils=gnu"
+readlink -f "$(command -v ls)" 2>/dev/null | grep -q busybox && echo "coreutils=busybox"
+command -v sudo >/dev/null 2>&1 && echo "sudo=true"
+echo "selinux=$(cat /sys/fs/selinux/enforce 2>/dev/null)"
+exit 0`
+
+// factsCache holds the facts of the host once they're gathered. It's shared by the copies of a Client.
+type factsCache struct {
+	mutex sync.Mutex
+	facts *hostFacts
+}
+
+// facts returns the facts of the host, running the probe the first time. Failures aren't cached, so that the
+// probe is retried.
+func (c *Client) facts(ctx context.Context) (*hostFacts, error) {
+	c.cachedFacts.mutex.Lock()
+	defer c.cachedFacts.mutex.Unlock()
+	if c.cachedFacts.facts != nil {
+		return c.cachedFacts.facts, nil
+	}
+
+	stdout, _, err := runCommand(ctx, c, false, factsProbe, "")
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to gather the facts of the host")
+	}
+	c.cachedFacts.facts = parseFacts(stdout)
+	return c.cachedFacts.facts, nil
+}
+
+func parseFacts(output string) *hostFacts {
+	values := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		if i := strings.Index(scanner.Text(), "="); i > 0 {
+			values[scanner.Text()[:i]] = strings.Trim(strings.TrimSpace(scanner.Text()[i+1:]), `"'`)
+		}
+	}
+
+	facts := &hostFacts{
+		Kernel:         values["kernel"],
+		Arch:           values["arch"],
+		Hostname:       values["hostname"],
+		DistroID:       values["distro_id"],
+		DistroVersion:  values["distro_version"],
+		PackageManager: values["package_manager"],
+		Coreutils:      values["coreutils"],
+		Sudo:           values["sudo"] == "true",
+	}
+	facts.CPUs, _ = strconv.Atoi(values["cpus"])
+	if memory, err := strconv.Atoi(values["memory_kb"]); err == nil {
+		facts.MemoryMB = memory / 1024
+	}
+	if facts.PackageManager == "apt-get" {
+		facts.PackageManager = "apt"
+	}
+	if facts.Coreutils == "" {
+		facts.Coreutils = "other"
+	}
+
+	switch {
+	case values["systemd"] == "true":
+		facts.InitSystem = "systemd"
+	case values["openrc"] == "true":
+		facts.InitSystem = "openrc"
+	default:
+		// /proc/1/comm names the init program, such as init for sysvinit and busybox, or runit.
+		facts.InitSystem = values["init"]
+	}
+
+	switch values["selinux"] {
+	case "1":
+		facts.SELinux = "enforcing"
+	case "0":
+		facts.SELinux = "permissive"
+	default:
+		facts.SELinux = "disabled"
+	}
+	return facts
+}
@@ -0,0 +1,86 @@
+package linux
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestParseFacts(t *testing.T) {
+	debian := `kernel=6.1.0-18-amd64
+arch=x86_64
+hostname=web1
+distro_id=debian
+distro_version="12"
+cpus=4
+memory_kb=8136492
+init=systemd
+systemd=true
+package_manager=apt-get
+coreutils=gnu
+sudo=true
+selinux=
+`
+	expected := &hostFacts{
+		Kernel: "6.1.0-18-amd64", Arch: "x86_64", Hostname: "web1", DistroID: "debian", DistroVersion: "12",
+		CPUs: 4, MemoryMB: 7945, InitSystem: "systemd", PackageManager: "apt", Coreutils: "gnu", Sudo: true,
+		SELinux: "disabled",
+	}
+	if facts := parseFacts(debian); !reflect.DeepEqual(facts, expected) {
+		t.Errorf("Unexpected facts:\n%+v\nexpected:\n%+v", facts, expected)
+	}
+
+	alpine := `kernel=6.6.14-0-lts
+arch=aarch64
+hostname=edge
+distro_id=alpine
+distro_version=3.19.1
+cpus=2
+memory_kb=1004608
+init=init
+openrc=true
+package_manager=apk
+coreutils=busybox
+selinux=1
+`
+	expected = &hostFacts{
+		Kernel: "6.6.14-0-lts", Arch: "aarch64", Hostname: "edge", DistroID: "alpine", DistroVersion: "3.19.1",
+		CPUs: 2, MemoryMB: 981, InitSystem: "openrc", PackageManager: "apk", Coreutils: "busybox", Sudo: false,
+		SELinux: "enforcing",
+	}
+	if facts := parseFacts(alpine); !reflect.DeepEqual(facts, expected) {
+		t.Errorf("Unexpected facts:\n%+v\nexpected:\n%+v", facts, expected)
+	}
+}
+
+func TestFactsAreCached(t *testing.T) {
+	client, executor := testFakeClient(map[string]fakeResult{
+		factsProbe: {stdout: "hostname=web1\nkernel=6.1.0\n"},
+	})
+	client.cachedFacts = &factsCache{}
+
+	d := schema.TestResourceDataRaw(t, systemInfoDataSource().Schema, map[string]interface{}{})
+	if diags := systemInfoDataSourceRead(context.Background(), d, client); diags.HasError() {
+		t.Fatalf("Unable to read system info: %v", diags)
+	}
+	if d.Id() != "web1" || d.Get("kernel") != "6.1.0" {
+		t.Errorf("Unexpected system info: %q %q", d.Id(), d.Get("kernel"))
+	}
+	if _, err := client.as("svc").facts(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assertCommands(t, executor, factsProbe)
+}
+
+func TestLocalFacts(t *testing.T) {
+	client := &Client{executor: &localExecutor{}, cachedFacts: &factsCache{}, readOnly: true}
+	facts, err := client.facts(context.Background())
+	if err != nil {
+		t.Fatalf("Probe should run, even in read_only mode: %v", err)
+	}
+	if facts.Kernel == "" || facts.Arch == "" || facts.CPUs < 1 || facts.MemoryMB < 1 {
+		t.Errorf("Facts should be gathered: %+v", facts)
+	}
+}
@@ -0,0 +1,179 @@
+package linux
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+)
+
+const (
+	fileTransferAuto  = "auto"
+	fileTransferSFTP  = "sftp"
+	fileTransferShell = "shell"
+)
+
+// sftpClient returns the SFTP client to access files with, or nil if files are accessed with shell commands
+// instead. In auto mode, SFTP is used whenever the connection offers it. SFTP acts as the login user, so it
+// isn't used for clients that run commands as another user. Exported scripts are made of shell commands, so
+// it isn't used while exporting either.
+func (c *Client) sftpClient(ctx context.Context) (*sftp.Client, error) {
+	if c.fileTransfer == fileTransferShell || c.runAs != "" || c.exporting() {
+		return nil, nil
+	}
+	executor, ok := c.executor.(*sshExecutor)
+	if !ok {
+		if c.fileTransfer == fileTransferSFTP {
+			return nil, fmt.Errorf("file_transfer = %q needs an ssh connection", fileTransferSFTP)
+		}
+		return nil, nil
+	}
+
+	s, err := executor.sftpClient(ctx)
+	if err != nil {
+		if c.fileTransfer == fileTransferSFTP {
+			return nil, errors.Wrap(err, "Unable to start SFTP")
+		}
+		log.Printf("[DEBUG] SFTP is unavailable, using shell commands for files: %v", err)
+		return nil, nil
+	}
+	return s, nil
+}
+
+// sftpRequest runs request, an SFTP operation on path, with s, and records it in the audit log. If the
+// connection turns out to be lost, for instance dropped by a NAT, it's redialed and request is run once more
+// with a new SFTP client. The operations are idempotent, so running them again is safe.
+func (c *Client) sftpRequest(ctx context.Context, s *sftp.Client, operation string, path string, request func(*sftp.Client) error) error {
+	return c.auditSFTP(ctx, operation, path, func() error {
+		err := request(s)
+		if !sftpConnectionLost(err) {
+			return err
+		}
+		log.Printf("[WARN] SFTP connection lost, reconnecting: %v", err)
+		s, err = c.executor.(*sshExecutor).reconnectSFTP(ctx, s)
+		if err != nil {
+			return errors.Wrap(err, "Unable to restart SFTP")
+		}
+		return request(s)
+	})
+}
+
+// sftpConnectionLost tells whether err means that the SFTP session, or the connection under it, is gone.
+func sftpConnectionLost(err error) bool {
+	return errors.Is(err, sftp.ErrSSHFxConnectionLost) || errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed)
+}
+
+func sftpReadFile(s *sftp.Client, path string) (string, error) {
+	f, err := s.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var content strings.Builder
+	if _, err := f.WriteTo(&content); err != nil {
+		return "", err
+	}
+	return content.String(), nil
+}
+
+func sftpWriteFile(s *sftp.Client, path string, content string) error {
+	f, err := s.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, strings.NewReader(content)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// sftpGetDetails returns the owner and permissions of path in the same form as getDetails.
+func sftpGetDetails(ctx context.Context, client *Client, s *sftp.Client, path string) (string, int, error) {
+	info, err := s.Lstat(path)
+	if os.IsNotExist(err) {
+		return "", 0, &notFoundError{"File", "path", path}
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	stat, ok := info.Sys().(*sftp.FileStat)
+	if !ok {
+		return "", 0, fmt.Errorf("No ownership information for %s", path)
+	}
+
+	user, err := lookupIDName(ctx, client, "passwd", int(stat.UID))
+	if err != nil {
+		return "", 0, err
+	}
+	group, err := lookupIDName(ctx, client, "group", int(stat.GID))
+	if err != nil {
+		return "", 0, err
+	}
+	permissions, err := strconv.Atoi(fmt.Sprintf("%o", info.Mode().Perm()))
+	if err != nil {
+		return "", 0, err
+	}
+	return fmt.Sprintf("%s:%s", user, group), permissions, nil
+}
+
+func sftpChmod(s *sftp.Client, path string, permissions int) error {
+	mode, err := strconv.ParseUint(strconv.Itoa(permissions), 8, 32)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("Invalid permissions %d", permissions))
+	}
+	return s.Chmod(path, os.FileMode(mode))
+}
+
+func sftpChown(ctx context.Context, client *Client, s *sftp.Client, path string, owner string) error {
+	parts := strings.SplitN(owner, ":", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("owner should be of the form user:group")
+	}
+	uid, err := lookupID(ctx, client, "passwd", parts[0])
+	if err != nil {
+		return err
+	}
+	gid, err := lookupID(ctx, client, "group", parts[1])
+	if err != nil {
+		return err
+	}
+	return s.Chown(path, uid, gid)
+}
+
+// lookupIDName returns the name of the user or group with the given id, from the passwd or group database.
+// Like ls, it falls back to the id if there's no such entry.
+func lookupIDName(ctx context.Context, client *Client, database string, id int) (string, error) {
+	command := fmt.Sprintf("getent %s %d", database, id)
+	stdout, _, err := runCommand(ctx, client, false, command, "")
+	if err != nil || stdout == "" {
+		return strconv.Itoa(id), nil
+	}
+	return strings.Split(stdout, ":")[0], nil
+}
+
+// lookupID returns the id of the user or group with the given name, from the passwd or group database.
+func lookupID(ctx context.Context, client *Client, database string, name string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	command := shellCommand("getent", database, name)
+	stdout, _, err := runCommand(ctx, client, false, command, "")
+	if err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("Command failed: %s", command))
+	}
+	fields := strings.Split(strings.TrimSpace(stdout), ":")
+	if len(fields) < 3 {
+		return 0, fmt.Errorf("No %s entry found for %s", database, name)
+	}
+	return strconv.Atoi(fields[2])
+}
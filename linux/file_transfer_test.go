@@ -0,0 +1,63 @@
+package linux
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestFileOverSFTP(t *testing.T) {
+	server := startTestSSHServer(t, &ssh.ServerConfig{PasswordCallback: acceptAnyPassword})
+	client := testSSHClient(t, server, 0)
+	client.fileTransfer = fileTransferSFTP
+	path := filepath.Join(t.TempDir(), "testfile")
+
+	// Binary content doesn't survive every shell, so it's the case SFTP is for.
+	content := "binary\x00content\xff\n"
+	if err := writeContent(context.Background(), client, path, content); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+	if written, err := os.ReadFile(path); err != nil || string(written) != content {
+		t.Errorf("Content should be written as is: %q, %v", written, err)
+	}
+	if read, err := readFile(context.Background(), client, path); err != nil || read != content {
+		t.Errorf("Content should be read as is: %q, %v", read, err)
+	}
+
+	if err := applyPermissions(context.Background(), client, path, 640); err != nil {
+		t.Fatalf("Unable to apply permissions: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("Permissions should be applied: %v, %v", info.Mode(), err)
+	}
+	if _, permissions, err := getDetails(context.Background(), client, path); err != nil || permissions != 640 {
+		t.Errorf("Permissions should be read back: %d, %v", permissions, err)
+	}
+
+	if _, _, err := getDetails(context.Background(), client, path+".missing"); err == nil || err.Error() != "File not found with path "+path+".missing" {
+		t.Errorf("Missing file should be reported as not found: %v", err)
+	}
+}
+
+func TestFileTransferMode(t *testing.T) {
+	client, executor := testFakeClient(nil)
+	if s, err := client.sftpClient(context.Background()); s != nil || err != nil {
+		t.Errorf("Connections without sftp should fall back to shell commands: %v", err)
+	}
+
+	client.fileTransfer = fileTransferSFTP
+	if err := writeContent(context.Background(), client, "/etc/testfile", "testcontent"); err == nil {
+		t.Errorf("Forcing sftp on a connection without it should fail")
+	}
+	assertCommands(t, executor)
+
+	server := startTestSSHServer(t, &ssh.ServerConfig{PasswordCallback: acceptAnyPassword})
+	sshClient := testSSHClient(t, server, 0)
+	sshClient.fileTransfer = fileTransferShell
+	if s, err := sshClient.sftpClient(context.Background()); s != nil || err != nil {
+		t.Errorf("Shell mode shouldn't use sftp: %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package linux
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// parseHostKey normalizes a pinned host key to its SHA256 fingerprint. The key can be given either as
+// a fingerprint ("SHA256:...") or as a public key in authorized_keys format ("ssh-ed25519 AAAA...").
+func parseHostKey(hostKey string) (string, error) {
+	hostKey = strings.TrimSpace(hostKey)
+	if strings.HasPrefix(hostKey, "SHA256:") {
+		return hostKey, nil
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostKey))
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("Unable to parse host key %q", hostKey))
+	}
+	return ssh.FingerprintSHA256(key), nil
+}
+
+func hostKeyMismatch(hostname string, expected []string, presented ssh.PublicKey) error {
+	return fmt.Errorf(
+		"Host key verification failed for %s: expected %s, presented %s",
+		hostname, strings.Join(expected, " or "), ssh.FingerprintSHA256(presented),
+	)
+}
+
+// knownHostsMutex serializes appends to known_hosts files when trusting on first use.
+var knownHostsMutex sync.Mutex
+
+func appendKnownHost(path string, hostname string, key ssh.PublicKey) error {
+	knownHostsMutex.Lock()
+	defer knownHostsMutex.Unlock()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("Unable to open %s", path))
+	}
+	defer f.Close()
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+	if _, err := fmt.Fprintln(f, line); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("Unable to write to %s", path))
+	}
+	log.Printf("[INFO] Trusting host key %s for %s on first use", ssh.FingerprintSHA256(key), hostname)
+	return nil
+}
+
+// defaultKnownHostsFile is the known_hosts file that host keys are verified with if neither known_hosts_file
+// nor host_key is set, if it exists.
+func defaultKnownHostsFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	path := filepath.Join(home, ".ssh", "known_hosts")
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// hostKeyCallback verifies the host key with the pinned keys and the known_hosts file. Without either, it's
+// left unverified if insecure_ignore_host_key is set, and verified with ~/.ssh/known_hosts otherwise.
+func (c *Config) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	if c.KnownHostsFile == "" && len(c.HostKeys) == 0 {
+		if c.TrustOnFirstUse {
+			return nil, fmt.Errorf("trust_on_first_use requires known_hosts_file to be set")
+		}
+		if c.InsecureIgnoreHostKey {
+			log.Printf("[WARN] insecure_ignore_host_key is set, the host key of %s will not be verified", c.Host)
+			return ssh.InsecureIgnoreHostKey(), nil
+		}
+		config := *c
+		config.KnownHostsFile = defaultKnownHostsFile()
+		if config.KnownHostsFile == "" {
+			return nil, fmt.Errorf(
+				"Unable to verify the host key of %s: set known_hosts_file or host_key, or insecure_ignore_host_key to skip verification",
+				c.Host,
+			)
+		}
+		return config.hostKeyCallback()
+	}
+
+	pinned := make([]string, len(c.HostKeys))
+	for i, hostKey := range c.HostKeys {
+		fingerprint, err := parseHostKey(hostKey)
+		if err != nil {
+			return nil, err
+		}
+		pinned[i] = fingerprint
+	}
+
+	var knownHosts ssh.HostKeyCallback
+	if c.KnownHostsFile != "" {
+		if c.TrustOnFirstUse {
+			if err := os.MkdirAll(filepath.Dir(c.KnownHostsFile), 0700); err != nil {
+				return nil, errors.Wrap(err, "Unable to create known_hosts directory")
+			}
+			f, err := os.OpenFile(c.KnownHostsFile, os.O_RDONLY|os.O_CREATE, 0600)
+			if err != nil {
+				return nil, errors.Wrap(err, "Unable to create known_hosts file")
+			}
+			f.Close()
+		}
+		var err error
+		knownHosts, err = knownhosts.New(c.KnownHostsFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to read known_hosts file")
+		}
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		fingerprint := ssh.FingerprintSHA256(key)
+		for _, p := range pinned {
+			if p == fingerprint {
+				return nil
+			}
+		}
+
+		expected := pinned
+		if knownHosts != nil {
+			err := knownHosts(hostname, remote, key)
+			if err == nil {
+				return nil
+			}
+			var keyErr *knownhosts.KeyError
+			if !errors.As(err, &keyErr) {
+				return err
+			}
+			if len(keyErr.Want) == 0 && len(pinned) == 0 {
+				if c.TrustOnFirstUse {
+					return appendKnownHost(c.KnownHostsFile, hostname, key)
+				}
+				return fmt.Errorf(
+					"Host key verification failed for %s: host is not in %s, presented %s",
+					hostname, c.KnownHostsFile, fingerprint,
+				)
+			}
+			for _, want := range keyErr.Want {
+				expected = append(expected, ssh.FingerprintSHA256(want.Key))
+			}
+		}
+		return hostKeyMismatch(hostname, expected, key)
+	}, nil
+}
@@ -0,0 +1,140 @@
+package linux
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func generateHostKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate key: %v", err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("Unable to convert key: %v", err)
+	}
+	return key
+}
+
+var testRemoteAddr = &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 22}
+
+func TestPinnedHostKey(t *testing.T) {
+	key := generateHostKey(t)
+	other := generateHostKey(t)
+
+	for _, pinned := range []string{ssh.FingerprintSHA256(key), string(ssh.MarshalAuthorizedKey(key))} {
+		config := Config{Host: "127.0.0.1", HostKeys: []string{pinned}}
+		callback, err := config.hostKeyCallback()
+		if err != nil {
+			t.Fatalf("Unable to create callback: %v", err)
+		}
+		if err := callback("127.0.0.1:22", testRemoteAddr, key); err != nil {
+			t.Errorf("Pinned key %q should be accepted: %v", pinned, err)
+		}
+		err = callback("127.0.0.1:22", testRemoteAddr, other)
+		if err == nil {
+			t.Fatalf("Unpinned key should be rejected")
+		}
+		if !strings.Contains(err.Error(), ssh.FingerprintSHA256(key)) ||
+			!strings.Contains(err.Error(), ssh.FingerprintSHA256(other)) {
+			t.Errorf("Error should show the expected and presented fingerprints: %v", err)
+		}
+	}
+}
+
+func TestInvalidPinnedHostKey(t *testing.T) {
+	config := Config{Host: "127.0.0.1", HostKeys: []string{"not a key"}}
+	if _, err := config.hostKeyCallback(); err == nil {
+		t.Errorf("Invalid host key should be rejected")
+	}
+}
+
+func TestTrustOnFirstUse(t *testing.T) {
+	key := generateHostKey(t)
+	other := generateHostKey(t)
+	path := filepath.Join(t.TempDir(), "ssh", "known_hosts")
+
+	config := Config{Host: "127.0.0.1", KnownHostsFile: path, TrustOnFirstUse: true}
+	callback, err := config.hostKeyCallback()
+	if err != nil {
+		t.Fatalf("Unable to create callback: %v", err)
+	}
+	if err := callback("127.0.0.1:22", testRemoteAddr, key); err != nil {
+		t.Fatalf("Unknown host should be trusted on first use: %v", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil || !strings.Contains(string(content), strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))) {
+		t.Fatalf("Host key should be recorded in known_hosts: %q, %v", content, err)
+	}
+
+	callback, err = config.hostKeyCallback()
+	if err != nil {
+		t.Fatalf("Unable to create callback: %v", err)
+	}
+	if err := callback("127.0.0.1:22", testRemoteAddr, key); err != nil {
+		t.Errorf("Recorded key should be accepted: %v", err)
+	}
+	if err := callback("127.0.0.1:22", testRemoteAddr, other); err == nil {
+		t.Errorf("Changed host key should be rejected")
+	}
+}
+
+func TestUnknownHostWithoutTrustOnFirstUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	config := Config{Host: "127.0.0.1", KnownHostsFile: path}
+	callback, err := config.hostKeyCallback()
+	if err != nil {
+		t.Fatalf("Unable to create callback: %v", err)
+	}
+	if err := callback("127.0.0.1:22", testRemoteAddr, generateHostKey(t)); err == nil {
+		t.Errorf("Unknown host should be rejected")
+	}
+}
+
+func TestDefaultKnownHostsFile(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	config := Config{Host: "127.0.0.1"}
+	if _, err := config.hostKeyCallback(); err == nil {
+		t.Errorf("Host key verification shouldn't be skipped unless insecure_ignore_host_key is set")
+	}
+
+	key := generateHostKey(t)
+	path := filepath.Join(home, ".ssh", "known_hosts")
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := appendKnownHost(path, "127.0.0.1:22", key); err != nil {
+		t.Fatal(err)
+	}
+	callback, err := config.hostKeyCallback()
+	if err != nil {
+		t.Fatalf("~/.ssh/known_hosts should be used by default: %v", err)
+	}
+	if err := callback("127.0.0.1:22", testRemoteAddr, key); err != nil {
+		t.Errorf("Key in ~/.ssh/known_hosts should be accepted: %v", err)
+	}
+	if err := callback("127.0.0.1:22", testRemoteAddr, generateHostKey(t)); err == nil {
+		t.Errorf("Key not in ~/.ssh/known_hosts should be rejected")
+	}
+
+	config.InsecureIgnoreHostKey = true
+	callback, err = config.hostKeyCallback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := callback("127.0.0.1:22", testRemoteAddr, generateHostKey(t)); err != nil {
+		t.Errorf("insecure_ignore_host_key should skip the verification: %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package linux
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// localExecutor runs commands on the machine the provider runs on.
+type localExecutor struct{}
+
+func (e *localExecutor) Execute(ctx context.Context, command string, stdin io.Reader, stdout io.Writer, stderr io.Writer) (int, error) {
+	return runLocal(ctx, exec.CommandContext(ctx, "/bin/sh", "-c", command), stdin, stdout, stderr)
+}
+
+// How long a cancelled command has to exit after it's sent SIGTERM, before it's killed.
+const localKillDelay = 5 * time.Second
+
+// runLocal runs cmd, which was created with ctx, and returns its exit status.
+func runLocal(ctx context.Context, cmd *exec.Cmd, stdin io.Reader, stdout io.Writer, stderr io.Writer) (int, error) {
+	stopOnCancel(cmd)
+	cmd.WaitDelay = localKillDelay
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// Like sshd, don't wait for stdin to be closed once the command is done.
+	stdinPipe, err := cmd.StdinPipe()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("Unable to run %s", cmd.Path))
+	}
+	go func() {
+		io.Copy(stdinPipe, stdin)
+		stdinPipe.Close()
+	}()
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		return 0, errors.Wrap(ctx.Err(), fmt.Sprintf("Stopped %s", cmd.Path))
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("Unable to run %s", cmd.Path))
+	}
+	return 0, nil
+}
@@ -0,0 +1,92 @@
+package linux
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestLocalExecutor(t *testing.T) {
+	executor := &localExecutor{}
+
+	var stdout, stderr bytes.Buffer
+	status, err := executor.Execute(context.Background(), "cat; echo oops >&2; exit 4", strings.NewReader("content"), &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("Command should run: %v", err)
+	}
+	if status != 4 || stdout.String() != "content" || stderr.String() != "oops\n" {
+		t.Errorf("Unexpected result: status %d, stdout %q, stderr %q", status, stdout.String(), stderr.String())
+	}
+}
+
+func TestLocalFileResource(t *testing.T) {
+	client := &Client{executor: &localExecutor{}}
+	path := t.TempDir() + "/testfile"
+
+	if err := createFile(context.Background(), client, path, false); err != nil {
+		t.Fatalf("Unable to create file: %v", err)
+	}
+	if err := writeContent(context.Background(), client, path, "testcontent"); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+	if err := applyPermissions(context.Background(), client, path, 640); err != nil {
+		t.Fatalf("Unable to chmod file: %v", err)
+	}
+	content, err := readFile(context.Background(), client, path)
+	if err != nil || content != "testcontent" {
+		t.Errorf("File should hold its content: %q, %v", content, err)
+	}
+	_, permissions, err := getDetails(context.Background(), client, path)
+	if err != nil || permissions != 640 {
+		t.Errorf("File should have its permissions: %d, %v", permissions, err)
+	}
+}
+
+func TestLocalFileResourceHostilePath(t *testing.T) {
+	client := &Client{executor: &localExecutor{}}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "it's a $(touch injected) file")
+
+	if err := createFile(context.Background(), client, path, false); err != nil {
+		t.Fatalf("Unable to create file: %v", err)
+	}
+	if err := writeContent(context.Background(), client, path, "testcontent"); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+	if err := moveFile(context.Background(), client, path, path+"; touch injected"); err != nil {
+		t.Fatalf("Unable to move file: %v", err)
+	}
+	content, err := readFile(context.Background(), client, path+"; touch injected")
+	if err != nil || content != "testcontent" {
+		t.Errorf("File should be moved with its content: %q, %v", content, err)
+	}
+	if err := deleteFile(context.Background(), client, path+"; touch injected"); err != nil {
+		t.Fatalf("Unable to delete file: %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 0 {
+		t.Errorf("Nothing else should have been created: %v, %v", entries, err)
+	}
+}
+
+func TestLocalExecutorCancelled(t *testing.T) {
+	executor := &localExecutor{}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	var stdout, stderr bytes.Buffer
+	if _, err := executor.Execute(ctx, "sleep 30", strings.NewReader(""), &stdout, &stderr); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expired context should stop the command: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Command should be stopped when the context expires, took %s", elapsed)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package linux
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// stopOnCancel makes cmd run in its own process group, and makes cancelling it send SIGTERM to the whole
+// group, so that the commands started by the shell stop too.
+func stopOnCancel(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package linux
+
+import (
+	"os/exec"
+)
+
+// stopOnCancel leaves cmd to be killed when it's cancelled, since Windows has no SIGTERM.
+func stopOnCancel(cmd *exec.Cmd) {}
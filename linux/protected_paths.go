@@ -0,0 +1,49 @@
+package linux
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// defaultProtectedPaths are the paths that files and folders can't be created at, moved from or to, or
+// deleted at, unless protected_paths is set.
+var defaultProtectedPaths = []string{
+	"/", "/bin", "/boot", "/dev", "/etc", "/home", "/lib", "/lib64", "/opt", "/proc", "/root", "/run", "/sbin",
+	"/srv", "/sys", "/tmp", "/usr", "/var",
+}
+
+// checkPath returns an error if files can't be created, moved or deleted at p: if it's one of the protected
+// paths, or if managed paths are set and it isn't in any of them. Paths are compared once cleaned, so that
+// /etc/.. counts as / and an empty interpolation in /${var.dir} as /.
+func (c *Client) checkPath(p string) error {
+	cleaned := path.Clean(p)
+	for _, protected := range c.protectedPaths {
+		if cleaned == path.Clean(protected) {
+			return fmt.Errorf("%s is a protected path, see the provider's protected_paths", p)
+		}
+	}
+	if len(c.managedPaths) == 0 {
+		return nil
+	}
+	for _, managed := range c.managedPaths {
+		managed = path.Clean(managed)
+		if cleaned == managed || strings.HasPrefix(cleaned, strings.TrimSuffix(managed, "/")+"/") {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is outside the provider's managed_paths", p)
+}
+
+// checkPlannedPath refuses plans for files and folders at paths that checkPath rejects, so that they fail
+// before anything is applied. Deletions aren't planned with it, so deleteFile checks the path again.
+func checkPlannedPath(ctx context.Context, d *schema.ResourceDiff, m interface{}) error {
+	client, ok := m.(*Client)
+	if !ok || client == nil || !d.NewValueKnown("path") {
+		return nil
+	}
+	return client.checkPath(d.Get("path").(string))
+}
@@ -0,0 +1,70 @@
+package linux
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+func TestCheckPath(t *testing.T) {
+	client := &Client{protectedPaths: defaultProtectedPaths, managedPaths: []string{"/srv/app", "/etc/app/"}}
+	for path, allowed := range map[string]bool{
+		"/srv/app":          true,
+		"/srv/app/":         true,
+		"/srv/app/config":   true,
+		"/etc/app/app.conf": true,
+		"/srv/application":  false,
+		"/srv":              false,
+		"/etc/motd":         false,
+		"/srv/app/../../":   false,
+		"/":                 false,
+	} {
+		if err := client.checkPath(path); (err == nil) != allowed {
+			t.Errorf("Unexpected check of %s: %v", path, err)
+		}
+	}
+
+	client.managedPaths = nil
+	for path, allowed := range map[string]bool{
+		"/etc/motd":  true,
+		"/home/user": true,
+		"/":          false,
+		"//":         false,
+		"/etc/":      false,
+		"/etc/..":    false,
+		"/usr/./":    false,
+	} {
+		if err := client.checkPath(path); (err == nil) != allowed {
+			t.Errorf("Unexpected check of %s: %v", path, err)
+		}
+	}
+}
+
+func TestProtectedPathIsNotDeleted(t *testing.T) {
+	client, executor := testFakeClient(nil)
+	client.protectedPaths = defaultProtectedPaths
+
+	if err := deleteFile(context.Background(), client, "/var/"); err == nil {
+		t.Errorf("Deleting a protected path should fail")
+	}
+	if err := moveFile(context.Background(), client, "/etc", "/etc.old"); err == nil {
+		t.Errorf("Moving a protected path should fail")
+	}
+	assertCommands(t, executor)
+}
+
+func TestProtectedPathIsRefusedAtPlan(t *testing.T) {
+	client := &Client{protectedPaths: defaultProtectedPaths}
+	for _, name := range []string{"linux_file", "linux_folder"} {
+		r := resources()[name]
+		config := terraform.NewResourceConfigRaw(map[string]interface{}{"path": "/usr"})
+		if _, err := r.Diff(context.Background(), nil, config, client); err == nil {
+			t.Errorf("%s at a protected path should fail to plan", name)
+		}
+		config = terraform.NewResourceConfigRaw(map[string]interface{}{"path": "/usr/local/bin/tool"})
+		if _, err := r.Diff(context.Background(), nil, config, client); err != nil {
+			t.Errorf("%s should plan: %v", name, err)
+		}
+	}
+}
@@ -1,10 +1,15 @@
 package linux
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"regexp"
+	"strconv"
+	"time"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 )
 
 func NewProvider() *schema.Provider {
@@ -14,9 +19,35 @@ func NewProvider() *schema.Provider {
 func Provider() *schema.Provider {
 	return &schema.Provider{
 		Schema: map[string]*schema.Schema{
+			"connection_type": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc("TF_LINUX_CONNECTION_TYPE", connectionTypeSSH),
+				ValidateFunc: validation.StringInSlice([]string{connectionTypeSSH, connectionTypeLocal, connectionTypeContainer, connectionTypeChroot}, false),
+				Description:  "How to run commands: over ssh, on the local machine, in a container, or chrooted into a directory",
+			},
+			"container": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("TF_LINUX_CONTAINER", ""),
+				Description: "The name or ID of the container to run commands in",
+			},
+			"container_runtime": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "docker",
+				ValidateFunc: validation.StringInSlice([]string{"docker", "podman"}, false),
+				Description:  "The CLI used to run commands in the container",
+			},
+			"chroot_directory": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("TF_LINUX_CHROOT_DIRECTORY", ""),
+				Description: "The root filesystem to chroot into",
+			},
 			"user": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
 				DefaultFunc: schema.EnvDefaultFunc("TF_LINUX_SSH_USER", ""),
 				Description: "The username to ssh with",
 			},
@@ -26,64 +57,470 @@ func Provider() *schema.Provider {
 				DefaultFunc: schema.EnvDefaultFunc("TF_LINUX_USE_SUDO", ""),
 				Description: "Do certain commands need to be prefixed with sudo?",
 			},
+			"escalation": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Elem:        escalationResource(),
+				Description: "How commands that need root privileges get them",
+			},
 			"host": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
 				DefaultFunc: schema.EnvDefaultFunc("TF_LINUX_SSH_HOST", ""),
 				Description: "The host to ssh into",
 			},
 			"port": {
 				Type:        schema.TypeInt,
-				Required:    true,
+				Optional:    true,
 				DefaultFunc: schema.EnvDefaultFunc("TF_LINUX_SSH_PORT", 22),
 				Description: "The ssh port",
 			},
 			"password": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
 				DefaultFunc: schema.EnvDefaultFunc("TF_LINUX_SSH_PASSWORD", ""),
 				Description: "The password, if used for authentication",
 			},
 			"private_key": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("TF_LINUX_SSH_PRIVATE_KEY", defaultPrivateKey),
+				Description: "The location of the private key, if used for authentication",
+			},
+			"private_key_pem": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				DefaultFunc: schema.EnvDefaultFunc("TF_LINUX_SSH_PRIVATE_KEY_PEM", ""),
+				Description: "The content of the private key, if used for authentication",
+			},
+			"private_key_passphrase": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				DefaultFunc: schema.EnvDefaultFunc("TF_LINUX_SSH_PRIVATE_KEY_PASSPHRASE", ""),
+				Description: "The passphrase of the private key, if it is encrypted",
+			},
+			"certificate": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("TF_LINUX_SSH_CERTIFICATE", ""),
+				Description: "The OpenSSH user certificate for the private key, as its content or its location",
+			},
+			"keyboard_interactive": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Elem:        keyboardInteractiveResource(),
+				Description: "Answers to keyboard-interactive prompts, besides the password",
+			},
+			"known_hosts_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("TF_LINUX_SSH_KNOWN_HOSTS_FILE", ""),
+				Description: "The location of a known_hosts file used to verify the host key",
+			},
+			"host_key": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "Accepted host keys, as SHA256 fingerprints or public keys in authorized_keys format",
+			},
+			"trust_on_first_use": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Record the host key in known_hosts_file if the host isn't in it yet",
+			},
+			"insecure_ignore_host_key": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("TF_LINUX_SSH_INSECURE_IGNORE_HOST_KEY", false),
+				Description: "Don't verify the host key, unless known_hosts_file or host_key is set",
+			},
+			"bastion": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Elem:        bastionResource(),
+				Description: "Jump hosts to tunnel the connection through, in order",
+			},
+			"connect_timeout": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "30s",
+				ValidateFunc: validateDuration,
+				Description:  "How long to wait for each connection attempt",
+			},
+			"wait_for_ready": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "0s",
+				ValidateFunc: validateDuration,
+				Description:  "How long to keep retrying the connection until the host accepts it",
+			},
+			"keepalive_interval": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "30s",
+				ValidateFunc: validateDuration,
+				Description:  "How often to send keepalives on the connection, 0s to disable them",
+			},
+			"max_sessions": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      8,
+				ValidateFunc: validation.IntAtLeast(0),
+				Description:  "How many commands to run at once on the connection, 0 for no limit",
+			},
+			"use_ssh_config": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("TF_LINUX_USE_SSH_CONFIG", false),
+				Description: "Resolve the host through the OpenSSH client config",
+			},
+			"ssh_config_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("TF_LINUX_SSH_CONFIG_FILE", ""),
+				Description: "The location of the OpenSSH client config. Implies use_ssh_config",
+			},
+			"file_transfer": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc("TF_LINUX_FILE_TRANSFER", fileTransferAuto),
+				ValidateFunc: validation.StringInSlice([]string{fileTransferAuto, fileTransferSFTP, fileTransferShell}, false),
+				Description:  "How to transfer file content: over sftp, with shell commands, or sftp when available",
+			},
+			"audit_log": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("TF_LINUX_AUDIT_LOG", ""),
+				Description: "A file to append a JSON record of every command run on the host to",
+			},
+			"read_only": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("TF_LINUX_READ_ONLY", false),
+				Description: "Refuse to create, update or delete anything, and only run commands that read from the host",
+			},
+			"protected_paths": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString, ValidateFunc: validatePath},
+				Description: "Paths that files and folders can't be created at, moved from or to, or deleted at",
+			},
+			"managed_paths": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString, ValidateFunc: validatePath},
+				Description: "If set, files and folders can only be created, moved and deleted in these paths",
+			},
+			"environment": environmentSchema("Environment variables to run every command with"),
+			"script_export": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("TF_LINUX_SCRIPT_EXPORT", ""),
+				Description: "A shell script to append the commands that change the host to, instead of running them",
+			},
+		},
+		ResourcesMap:   resources(),
+		DataSourcesMap: dataSources(),
+		ConfigureFunc:  providerConfigure,
+	}
+}
+
+func resources() map[string]*schema.Resource {
+	resources := map[string]*schema.Resource{
+		"linux_group":  groupResource(),
+		"linux_user":   userResource(),
+		"linux_file":   fileResource(),
+		"linux_folder": folderResource(),
+	}
+	for name, resource := range resources {
+		resources[name] = auditedResource(name, readOnlyResource(name, environmentResource(resource)))
+	}
+	return resources
+}
+
+func dataSources() map[string]*schema.Resource {
+	dataSources := map[string]*schema.Resource{
+		"linux_system_info": systemInfoDataSource(),
+	}
+	for name, dataSource := range dataSources {
+		dataSources[name] = auditedResource("data."+name, dataSource)
+	}
+	return dataSources
+}
+
+func keyboardInteractiveResource() *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"prompt": {
+				Type:         schema.TypeString,
+				Required:     true,
+				ValidateFunc: validation.StringIsValidRegExp,
+				Description:  "Regular expression matching the prompt",
+			},
+			"answer": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Sensitive:   true,
+				Description: "The answer to send",
+			},
+		},
+	}
+}
+
+// expandKeyboardInteractive compiles the prompts, which the validation doesn't see when they're unknown until
+// apply, such as prompts made from another resource's attributes.
+func expandKeyboardInteractive(list []interface{}) ([]KeyboardInteractiveAnswer, error) {
+	answers := make([]KeyboardInteractiveAnswer, len(list))
+	for i, v := range list {
+		a := v.(map[string]interface{})
+		prompt, err := regexp.Compile(a["prompt"].(string))
+		if err != nil {
+			return nil, fmt.Errorf("keyboard_interactive prompt %q isn't a valid regular expression: %s", a["prompt"], err)
+		}
+		answers[i] = KeyboardInteractiveAnswer{Prompt: prompt, Answer: a["answer"].(string)}
+	}
+	return answers, nil
+}
+
+func bastionResource() *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"host": {
 				Type:        schema.TypeString,
 				Required:    true,
-				DefaultFunc: schema.EnvDefaultFunc("TF_LINUX_SSH_PRIVATE_KEY", "$HOME/.ssh/id_rsa"),
+				Description: "The bastion host to ssh into",
+			},
+			"port": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Default:     22,
+				Description: "The ssh port of the bastion",
+			},
+			"user": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "",
+				Description: "The username to ssh into the bastion with. Defaults to the provider's user",
+			},
+			"password": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "",
+				Sensitive:   true,
+				Description: "The password, if used for authentication",
+			},
+			"private_key": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     defaultPrivateKey,
 				Description: "The location of the private key, if used for authentication",
 			},
+			"private_key_pem": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "",
+				Sensitive:   true,
+				Description: "The content of the private key, if used for authentication",
+			},
+			"private_key_passphrase": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "",
+				Sensitive:   true,
+				Description: "The passphrase of the private key, if it is encrypted",
+			},
+			"certificate": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "",
+				Description: "The OpenSSH user certificate for the private key, as its content or its location",
+			},
+			"known_hosts_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "",
+				Description: "The location of a known_hosts file used to verify the host key. Defaults to the provider's known_hosts_file",
+			},
+			"host_key": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "Accepted host keys, as SHA256 fingerprints or public keys in authorized_keys format",
+			},
+			"trust_on_first_use": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Record the host key in known_hosts_file if the host isn't in it yet",
+			},
 		},
-		ResourcesMap: map[string]*schema.Resource{
-			"linux_group":  groupResource(),
-			"linux_user":   userResource(),
-			"linux_file":   fileResource(),
-			"linux_folder": folderResource(),
+	}
+}
+
+// defaultTimeout is how long creating, updating or deleting a resource may take, unless its timeouts block
+// says otherwise.
+const defaultTimeout = 5 * time.Minute
+
+func resourceTimeouts() *schema.ResourceTimeout {
+	return &schema.ResourceTimeout{
+		Create: schema.DefaultTimeout(defaultTimeout),
+		Update: schema.DefaultTimeout(defaultTimeout),
+		Delete: schema.DefaultTimeout(defaultTimeout),
+	}
+}
+
+func escalationResource() *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"method": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      escalationSudo,
+				ValidateFunc: validation.StringInSlice([]string{escalationSudo, escalationDoas, escalationSu, escalationRun0, escalationNone}, false),
+				Description:  "The command used to run commands as root",
+			},
+			"become_password": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "",
+				Sensitive:   true,
+				Description: "The password to give sudo when it asks for one",
+			},
+			"flags": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "Extra flags for the escalation command",
+			},
 		},
-		ConfigureFunc: providerConfigure,
 	}
 }
 
+func expandEscalation(list []interface{}) Escalation {
+	if len(list) == 0 || list[0] == nil {
+		return Escalation{Method: escalationSudo}
+	}
+	e := list[0].(map[string]interface{})
+	return Escalation{
+		Method:   e["method"].(string),
+		Password: e["become_password"].(string),
+		Flags:    expandStringList(e["flags"].([]interface{})),
+	}
+}
+
+func expandBastions(list []interface{}, defaults Config) []Config {
+	bastions := make([]Config, len(list))
+	for i, v := range list {
+		b := v.(map[string]interface{})
+		bastion := Config{
+			Host:                 b["host"].(string),
+			Port:                 b["port"].(int),
+			User:                 b["user"].(string),
+			Password:             b["password"].(string),
+			PrivateKey:           os.ExpandEnv(b["private_key"].(string)),
+			PrivateKeyPEM:        b["private_key_pem"].(string),
+			PrivateKeyPassphrase: b["private_key_passphrase"].(string),
+			Certificate:          b["certificate"].(string),
+			KnownHostsFile:       os.ExpandEnv(b["known_hosts_file"].(string)),
+			HostKeys:             expandStringList(b["host_key"].([]interface{})),
+			TrustOnFirstUse:      b["trust_on_first_use"].(bool),
+		}
+		if bastion.User == "" {
+			bastion.User = defaults.User
+		}
+		if bastion.KnownHostsFile == "" {
+			bastion.KnownHostsFile = defaults.KnownHostsFile
+		}
+		bastion.InsecureIgnoreHostKey = defaults.InsecureIgnoreHostKey
+		bastions[i] = bastion
+	}
+	return bastions
+}
+
 func providerConfigure(d *schema.ResourceData) (interface{}, error) {
-	user := d.Get("user").(string)
+	config := Config{
+		ConnectionType:        d.Get("connection_type").(string),
+		Container:             d.Get("container").(string),
+		ContainerRuntime:      d.Get("container_runtime").(string),
+		ChrootDirectory:       d.Get("chroot_directory").(string),
+		Host:                  d.Get("host").(string),
+		Port:                  d.Get("port").(int),
+		User:                  d.Get("user").(string),
+		Password:              d.Get("password").(string),
+		PrivateKey:            os.ExpandEnv(d.Get("private_key").(string)),
+		PrivateKeyPEM:         d.Get("private_key_pem").(string),
+		PrivateKeyPassphrase:  d.Get("private_key_passphrase").(string),
+		Certificate:           d.Get("certificate").(string),
+		KnownHostsFile:        os.ExpandEnv(d.Get("known_hosts_file").(string)),
+		HostKeys:              expandStringList(d.Get("host_key").([]interface{})),
+		TrustOnFirstUse:       d.Get("trust_on_first_use").(bool),
+		InsecureIgnoreHostKey: d.Get("insecure_ignore_host_key").(bool),
+		Escalation:            expandEscalation(d.Get("escalation").([]interface{})),
+		FileTransfer:          d.Get("file_transfer").(string),
+		AuditLog:              os.ExpandEnv(d.Get("audit_log").(string)),
+		ScriptExport:          os.ExpandEnv(d.Get("script_export").(string)),
+		ReadOnly:              d.Get("read_only").(bool),
+		Environment:           expandEnvironment(d.Get("environment").(map[string]interface{})),
+		ProtectedPaths:        defaultProtectedPaths,
+		ManagedPaths:          expandStringList(d.Get("managed_paths").([]interface{})),
+	}
+	// GetOk can't tell an empty list, which clears the defaults, from leaving protected_paths out, so the raw
+	// config is checked. It's null only when the provider isn't configured by Terraform, as in tests.
+	if raw := d.GetRawConfig(); !raw.IsNull() {
+		if protectedPaths := raw.GetAttr("protected_paths"); protectedPaths.IsWhollyKnown() && !protectedPaths.IsNull() {
+			config.ProtectedPaths = expandStringList(d.Get("protected_paths").([]interface{}))
+		}
+	} else if protectedPaths, ok := d.GetOk("protected_paths"); ok {
+		config.ProtectedPaths = expandStringList(protectedPaths.([]interface{}))
+	}
+	keyboardInteractive, err := expandKeyboardInteractive(d.Get("keyboard_interactive").([]interface{}))
+	if err != nil {
+		return nil, err
+	}
+	config.KeyboardInteractive = keyboardInteractive
+	config.ConnectTimeout, _ = time.ParseDuration(d.Get("connect_timeout").(string))
+	config.WaitForReady, _ = time.ParseDuration(d.Get("wait_for_ready").(string))
+	config.KeepaliveInterval, _ = time.ParseDuration(d.Get("keepalive_interval").(string))
+	config.MaxSessions = d.Get("max_sessions").(int)
+
+	if config.ConnectionType == connectionTypeSSH {
+		bastions := d.Get("bastion").([]interface{})
+		sshConfigFile := os.ExpandEnv(d.Get("ssh_config_file").(string))
+		if d.Get("use_ssh_config").(bool) || sshConfigFile != "" {
+			if sshConfigFile == "" {
+				sshConfigFile = os.ExpandEnv(defaultSSHConfigFile)
+			}
+			// The ssh config only adds bastions from ProxyJump if there are no bastion blocks.
+			if err := config.applySSHConfig(sshConfigFile, len(bastions) > 0); err != nil {
+				return nil, err
+			}
+		}
+		// The bastion blocks are expanded once the host is resolved, so that they inherit its user from the ssh
+		// config too.
+		if len(bastions) > 0 {
+			config.Bastions = expandBastions(bastions, config)
+		}
+	}
 
-	var useSudoBool bool
 	useSudo, ok := d.GetOk("use_sudo")
 	if !ok {
-		if user == "root" {
-			useSudoBool = false
+		if config.runsAsRoot() {
+			config.UseSudo = false
 		} else {
-			useSudoBool = true
+			config.UseSudo = true
 		}
 	} else {
-		useSudoBool = useSudo.(bool)
-	}
-	config := Config{
-		Host:       d.Get("host").(string),
-		Port:       d.Get("port").(int),
-		User:       user,
-		Password:   d.Get("password").(string),
-		PrivateKey: os.ExpandEnv(d.Get("private_key").(string)),
-		UseSudo:    useSudoBool,
+		v, err := strconv.ParseBool(useSudo.(string))
+		if err != nil {
+			return nil, fmt.Errorf("use_sudo should be true or false, got %q", useSudo)
+		}
+		config.UseSudo = v
 	}
 
-	log.Println("Initializing SSH client")
+	log.Printf("Initializing %s client", config.ConnectionType)
 	return config.Client()
 }
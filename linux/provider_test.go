@@ -4,6 +4,7 @@ import (
 	"context"
 	"testing"
 
+	"github.com/hashicorp/go-cty/cty"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
 )
@@ -37,3 +38,83 @@ func testAccPreCheck(t *testing.T) {
 		t.Fatal(diags[0].Summary)
 	}
 }
+
+func TestExpandBastions(t *testing.T) {
+	defaults := Config{User: "deploy", KnownHostsFile: "/etc/ssh/known_hosts"}
+	bastions := expandBastions([]interface{}{
+		map[string]interface{}{
+			"host": "bastion1", "port": 22, "user": "", "password": "", "private_key": "/key",
+			"private_key_pem": "", "private_key_passphrase": "", "certificate": "", "known_hosts_file": "", "host_key": []interface{}{}, "trust_on_first_use": false,
+		},
+		map[string]interface{}{
+			"host": "bastion2", "port": 2222, "user": "jump", "password": "", "private_key": "/key",
+			"private_key_pem": "", "private_key_passphrase": "", "certificate": "", "known_hosts_file": "/known_hosts", "host_key": []interface{}{"SHA256:abc"}, "trust_on_first_use": false,
+		},
+	}, defaults)
+
+	if len(bastions) != 2 || bastions[0].Host != "bastion1" || bastions[1].Host != "bastion2" {
+		t.Fatalf("Bastions should keep their order: %+v", bastions)
+	}
+	if bastions[0].User != "deploy" || bastions[0].KnownHostsFile != "/etc/ssh/known_hosts" {
+		t.Errorf("Bastion should inherit the provider's user and known_hosts_file: %+v", bastions[0])
+	}
+	if bastions[1].User != "jump" || bastions[1].Port != 2222 || bastions[1].KnownHostsFile != "/known_hosts" {
+		t.Errorf("Bastion settings should override the provider's: %+v", bastions[1])
+	}
+}
+
+func TestProviderConfigureLocal(t *testing.T) {
+	provider := Provider()
+	diags := provider.Configure(context.TODO(), terraform.NewResourceConfigRaw(map[string]interface{}{
+		"connection_type": "local",
+	}))
+	if diags.HasError() {
+		t.Fatalf("Local provider should configure without a host: %v", diags[0].Summary)
+	}
+	if _, ok := provider.Meta().(*Client).executor.(*localExecutor); !ok {
+		t.Errorf("Local provider should run commands locally")
+	}
+}
+
+func TestProviderProtectedPaths(t *testing.T) {
+	for _, test := range []struct {
+		protectedPaths cty.Value
+		expected       []string
+	}{
+		{cty.NullVal(cty.List(cty.String)), defaultProtectedPaths},
+		{cty.ListValEmpty(cty.String), nil},
+		{cty.ListVal([]cty.Value{cty.StringVal("/srv")}), []string{"/srv"}},
+	} {
+		provider := Provider()
+		configSchema := schema.InternalMap(provider.Schema).CoreConfigSchema()
+		attributes := map[string]cty.Value{}
+		for name, attributeType := range configSchema.ImpliedType().AttributeTypes() {
+			attributes[name] = cty.NullVal(attributeType)
+		}
+		attributes["connection_type"] = cty.StringVal("local")
+		attributes["protected_paths"] = test.protectedPaths
+
+		// Terraform passes the raw config along, as CtyValue, which NewResourceConfigShimmed leaves out.
+		config := terraform.NewResourceConfigShimmed(cty.ObjectVal(attributes), configSchema)
+		config.CtyValue = cty.ObjectVal(attributes)
+		diags := provider.Configure(context.Background(), config)
+		if diags.HasError() {
+			t.Fatalf("Unable to configure the provider: %v", diags[0].Summary)
+		}
+		if protectedPaths := provider.Meta().(*Client).protectedPaths; len(protectedPaths) != len(test.expected) {
+			t.Errorf("Unexpected protected paths for %#v: %v, expected %v", test.protectedPaths, protectedPaths, test.expected)
+		}
+	}
+}
+
+func TestProviderConfigureInvalidPrompt(t *testing.T) {
+	// Prompts that are unknown when validating, such as ones made from another resource's attributes, are
+	// only checked when configuring.
+	diags := Provider().Configure(context.Background(), terraform.NewResourceConfigRaw(map[string]interface{}{
+		"host":                 "example.com",
+		"keyboard_interactive": []interface{}{map[string]interface{}{"prompt": "[", "answer": "123456"}},
+	}))
+	if !diags.HasError() {
+		t.Errorf("Invalid prompt should fail to configure")
+	}
+}
@@ -0,0 +1,21 @@
+package linux
+
+import (
+	"fmt"
+	"time"
+)
+
+func validateDuration(vi interface{}, k string) (ws []string, errors []error) {
+	v, ok := vi.(string)
+	if !ok {
+		errors = append(errors, fmt.Errorf("%s should be a string", k))
+		return
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		errors = append(errors, fmt.Errorf("%s should be a duration such as \"30s\" or \"5m\": %v", k, err))
+	} else if d < 0 {
+		errors = append(errors, fmt.Errorf("%s should not be negative", k))
+	}
+	return
+}
@@ -0,0 +1,21 @@
+package linux
+
+import (
+	"testing"
+)
+
+func TestValidDuration(t *testing.T) {
+	for _, v := range []string{"0s", "30s", "5m", "1h30m"} {
+		if _, err := validateDuration(v, "timeout"); err != nil {
+			t.Errorf("%s should be a valid duration: %v", v, err)
+		}
+	}
+}
+
+func TestInvalidDuration(t *testing.T) {
+	for _, v := range []interface{}{"", "30", "five minutes", "-1m", 30} {
+		if _, err := validateDuration(v, "timeout"); err == nil {
+			t.Errorf("%v should be an invalid duration", v)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package linux
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// readOnlyCommands are the programs that commands may run in read_only mode.
+var readOnlyCommands = map[string]bool{
+	"cat":    true,
+	"getent": true,
+	"id":     true,
+	"ls":     true,
+	"test":   true,
+}
+
+// isReadOnlyCommand tells whether command only runs readOnlyCommands. It's deliberately strict: the programs
+// may be joined with && and ||, and their arguments quoted the way shellQuote does, but redirections, pipes,
+// substitutions and any other shell syntax are refused.
+func isReadOnlyCommand(command string) bool {
+	var segments [][]string
+	var words []string
+	var word strings.Builder
+	inWord, quoted := false, false
+	endWord := func() {
+		if inWord {
+			words = append(words, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+	endSegment := func() {
+		endWord()
+		segments = append(segments, words)
+		words = nil
+	}
+
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case quoted:
+			if c == '\'' {
+				quoted = false
+			} else {
+				word.WriteByte(c)
+			}
+		case c == '\'':
+			quoted, inWord = true, true
+		case c == '\\' && i+1 < len(command) && command[i+1] != '\n':
+			word.WriteByte(command[i+1])
+			inWord = true
+			i++
+		case c == ' ' || c == '\t':
+			endWord()
+		case (c == '&' || c == '|') && i+1 < len(command) && command[i+1] == c:
+			endSegment()
+			i++
+		case strings.IndexByte("|&;<>()$`\"\\{}*?[]~#!\n", c) >= 0:
+			return false
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if quoted {
+		return false
+	}
+	endSegment()
+
+	for _, segment := range segments {
+		if len(segment) == 0 || !readOnlyCommands[segment[0]] {
+			return false
+		}
+	}
+	return true
+}
+
+// readOnlyResource makes the Create, Update and Delete functions of resource, registered as name, fail
+// before running anything when the provider is read_only.
+func readOnlyResource(name string, resource *schema.Resource) *schema.Resource {
+	refuse := func(action string, f func(context.Context, *schema.ResourceData, interface{}) diag.Diagnostics) func(context.Context, *schema.ResourceData, interface{}) diag.Diagnostics {
+		if f == nil {
+			return nil
+		}
+		return func(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+			if m.(*Client).readOnly {
+				return diag.FromErr(fmt.Errorf("Refusing to %s %s %s: the provider is read_only", action, name, auditID(resource, d)))
+			}
+			return f(ctx, d, m)
+		}
+	}
+	resource.CreateContext = refuse("create", resource.CreateContext)
+	resource.UpdateContext = refuse("update", resource.UpdateContext)
+	resource.DeleteContext = refuse("delete", resource.DeleteContext)
+	return resource
+}
@@ -0,0 +1,80 @@
+package linux
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestIsReadOnlyCommand(t *testing.T) {
+	allowed := []string{
+		"getent passwd 1000",
+		"ls -ld /etc/motd",
+		shellCommand("cat", "/tmp/it's a $(touch injected) file; rm -rf /"),
+		shellCommand("id", "--name", "--groups", "user"),
+		"test -e /etc/motd || test -L /etc/motd",
+		"test -e /a && cat /a",
+	}
+	for _, command := range allowed {
+		if !isReadOnlyCommand(command) {
+			t.Errorf("Command should be allowed: %s", command)
+		}
+	}
+
+	refused := []string{
+		"",
+		"touch /etc/motd",
+		"cat > /etc/motd",
+		"cat /etc/motd | tee /etc/issue",
+		"cat /etc/motd; rm -rf /",
+		"cat $(rm -rf /)",
+		"cat `rm -rf /`",
+		"cat /etc/motd & rm -rf /",
+		"cat /etc/motd || ",
+		"FOO=bar cat /etc/motd",
+		"/bin/rm -rf /",
+		"'rm' -rf /",
+		"cat 'unterminated",
+		"cat /etc/motd\nrm -rf /",
+		"sudo cat /etc/shadow",
+	}
+	for _, command := range refused {
+		if isReadOnlyCommand(command) {
+			t.Errorf("Command should be refused: %q", command)
+		}
+	}
+}
+
+func TestReadOnlyRefusesChanges(t *testing.T) {
+	client, executor := testFakeClient(map[string]fakeResult{
+		"ls -ld /etc/motd": {stdout: "-rw-r--r-- 1 root root 5 Jan  1 00:00 /etc/motd\n"},
+		"cat /etc/motd":    {stdout: "hello"},
+	})
+	client.readOnly = true
+	file := resources()["linux_file"]
+
+	d := schema.TestResourceDataRaw(t, file.Schema, map[string]interface{}{"path": "/etc/motd"})
+	diags := file.CreateContext(context.Background(), d, client)
+	if !diags.HasError() || !strings.Contains(diags[0].Summary, "Refusing to create linux_file /etc/motd") {
+		t.Errorf("Create should be refused: %v", diags)
+	}
+	d.SetId("/etc/motd")
+	if diags := file.DeleteContext(context.Background(), d, client); !diags.HasError() {
+		t.Errorf("Delete should be refused")
+	}
+	assertCommands(t, executor)
+
+	if diags := file.ReadContext(context.Background(), d, client); diags.HasError() {
+		t.Fatalf("Read should be allowed: %v", diags)
+	}
+	if d.Get("content") != "hello" {
+		t.Errorf("File should be read: %q", d.Get("content"))
+	}
+
+	if _, _, err := runCommand(context.Background(), client, true, "rm -rf /etc/motd", ""); err == nil {
+		t.Errorf("Commands that change the host should be refused")
+	}
+	assertCommands(t, executor, "ls -ld /etc/motd", "cat /etc/motd")
+}
@@ -1,21 +1,28 @@
 package linux
 
 import (
+	"context"
 	"fmt"
+	"strconv"
 	"strings"
 
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
 )
 
 func fileResource() *schema.Resource {
 	return &schema.Resource{
-		Create: fileResourceCreateWrapper(false),
-		Read:   fileResourceReadWrapper(false),
-		Update: fileResourceUpdateWrapper(false),
-		Delete: fileResourceDelete,
+		CreateContext: fileResourceCreateWrapper(false),
+		ReadContext:   fileResourceReadWrapper(false),
+		UpdateContext: fileResourceUpdateWrapper(false),
+		DeleteContext: fileResourceDelete,
+		CustomizeDiff: checkPlannedPath,
+		Timeouts:      resourceTimeouts(),
 
 		Schema: map[string]*schema.Schema{
+			"environment": environmentSchema("Environment variables to run the commands for the file with, on top of the provider's"),
 			"path": {
 				Type:         schema.TypeString,
 				Required:     true,
@@ -32,6 +39,12 @@ func fileResource() *schema.Resource {
 				Optional: true,
 				Computed: true,
 			},
+			"run_as": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "",
+				Description: "The user to create, read, write, move and delete the file as",
+			},
 			"content": {
 				Type:     schema.TypeString,
 				Optional: true,
@@ -41,51 +54,85 @@ func fileResource() *schema.Resource {
 	}
 }
 
-func createFile(client *Client, path string, isFolder bool) error {
+func createFile(ctx context.Context, client *Client, path string, isFolder bool) error {
+	if err := client.checkPath(path); err != nil {
+		return err
+	}
 	var command string
 	if isFolder {
-		command = "mkdir -p"
+		command = shellCommand("mkdir", "-p", path)
 	} else {
-		command = "touch"
+		command = shellCommand("touch", path)
 	}
-	command = fmt.Sprintf("%s %s", command, path)
-	_, _, err := runCommand(client, false, command, "")
+	_, _, err := runMutatingCommand(ctx, client, false, command, "")
 	if err != nil {
 		return errors.Wrap(err, fmt.Sprintf("Command failed: %s", command))
 	}
 	return nil
 }
 
-func applyOwner(client *Client, path string, owner string) error {
-	command := fmt.Sprintf("chown %s %s", owner, path)
-	_, _, err := runCommand(client, true, command, "")
+func applyOwner(ctx context.Context, client *Client, path string, owner string) error {
+	// SFTP acts as the login user, so it can only stand in for commands that don't need sudo.
+	if !client.useSudo {
+		s, err := client.sftpClient(ctx)
+		if err != nil {
+			return err
+		}
+		if s != nil {
+			err := client.sftpRequest(ctx, s, "chown", path, func(s *sftp.Client) error {
+				return sftpChown(ctx, client, s, path, owner)
+			})
+			return errors.Wrap(err, fmt.Sprintf("SFTP chown failed: %s", path))
+		}
+	}
+	command := shellCommand("chown", "--", owner, path)
+	_, _, err := runMutatingCommand(ctx, client, true, command, "")
 	if err != nil {
 		return errors.Wrap(err, fmt.Sprintf("Command failed: %s", command))
 	}
 	return nil
 }
 
-func applyPermissions(client *Client, path string, permissions int) error {
-	command := fmt.Sprintf("chmod %d %s", permissions, path)
-	_, _, err := runCommand(client, true, command, "")
+func applyPermissions(ctx context.Context, client *Client, path string, permissions int) error {
+	if !client.useSudo {
+		s, err := client.sftpClient(ctx)
+		if err != nil {
+			return err
+		}
+		if s != nil {
+			err := client.sftpRequest(ctx, s, "chmod", path, func(s *sftp.Client) error { return sftpChmod(s, path, permissions) })
+			return errors.Wrap(err, fmt.Sprintf("SFTP chmod failed: %s", path))
+		}
+	}
+	command := shellCommand("chmod", strconv.Itoa(permissions), path)
+	_, _, err := runMutatingCommand(ctx, client, true, command, "")
 	if err != nil {
 		return errors.Wrap(err, fmt.Sprintf("Command failed: %s", command))
 	}
 	return nil
 }
 
-func writeContent(client *Client, path string, content string) error {
-	command := fmt.Sprintf("cat > %s", path)
-	_, _, err := runCommand(client, false, command, content)
+func writeContent(ctx context.Context, client *Client, path string, content string) error {
+	s, err := client.sftpClient(ctx)
+	if err != nil {
+		return err
+	}
+	if s != nil {
+		err := client.sftpRequest(ctx, s, "write", path, func(s *sftp.Client) error { return sftpWriteFile(s, path, content) })
+		return errors.Wrap(err, fmt.Sprintf("SFTP write failed: %s", path))
+	}
+	command := fmt.Sprintf("cat > %s", shellQuote(path))
+	_, _, err = runMutatingCommand(ctx, client, false, command, content)
 	if err != nil {
 		return errors.Wrap(err, fmt.Sprintf("Command failed: %s", command))
 	}
 	return nil
 }
 
-func rollback(client *Client, err error, errMsg string, path string) error {
+func rollback(ctx context.Context, client *Client, err error, errMsg string, path string) error {
 	err2 := errors.Wrap(err, errMsg)
-	if err3 := deleteFile(client, path); err3 != nil {
+	// Clean up even if the creation failed because it was cancelled or timed out.
+	if err3 := deleteFile(context.WithoutCancel(ctx), client, path); err3 != nil {
 		err3 = errors.Wrap(err2, err3.Error())
 		return errors.Wrap(err3, "Couldn't delete file.")
 	}
@@ -108,51 +155,74 @@ func parsePermissionString(perms string) int {
 		(permMap[perms[7:10]] * 1)
 }
 
-func fileResourceCreateWrapper(isFolder bool) func(*schema.ResourceData, interface{}) error {
-	return func(d *schema.ResourceData, m interface{}) error {
+func fileResourceCreateWrapper(isFolder bool) schema.CreateContextFunc {
+	return func(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 		client := m.(*Client)
 		path := d.Get("path").(string)
 		owner := d.Get("owner").(string)
 		permissions := d.Get("permissions").(int)
+		fileClient := client.as(d.Get("run_as").(string))
 
-		if err := createFile(client, path, isFolder); err != nil {
-			return errors.Wrap(err, "Couldn't create file")
+		if err := createFile(ctx, fileClient, path, isFolder); err != nil {
+			return diag.FromErr(errors.Wrap(err, "Couldn't create file"))
 		}
 
 		if owner != "" {
-			if err := applyOwner(client, path, owner); err != nil {
-				return rollback(client, err, "Couldn't apply owner, rolling back file creation", path)
+			if err := applyOwner(ctx, client, path, owner); err != nil {
+				return diag.FromErr(rollback(ctx, fileClient, err, "Couldn't apply owner, rolling back file creation", path))
 			}
 		}
 
 		if permissions != 0 {
-			if err := applyPermissions(client, path, permissions); err != nil {
-				return rollback(client, err, "Couldn't apply permissions, rolling back file creation", path)
+			if err := applyPermissions(ctx, client, path, permissions); err != nil {
+				return diag.FromErr(rollback(ctx, fileClient, err, "Couldn't apply permissions, rolling back file creation", path))
 			}
 		}
 
 		if !isFolder {
 			content := d.Get("content").(string)
 			if content != "" {
-				if err := writeContent(client, path, content); err != nil {
-					return rollback(client, err, "Couldn't write content, rolling back file creation", path)
+				if err := writeContent(ctx, fileClient, path, content); err != nil {
+					return diag.FromErr(rollback(ctx, fileClient, err, "Couldn't write content, rolling back file creation", path))
 				}
 			}
 		}
 
 		d.SetId(path)
-		return fileResourceReadWrapper(isFolder)(d, m)
+		if client.exporting() {
+			return nil
+		}
+		return fileResourceReadWrapper(isFolder)(ctx, d, m)
 	}
 }
 
-func getDetails(client *Client, path string) (string, int, error) {
-	command := fmt.Sprintf("ls -ld %s", path)
-	stdout, _, err := runCommand(client, false, command, "")
+func getDetails(ctx context.Context, client *Client, path string) (string, int, error) {
+	s, err := client.sftpClient(ctx)
+	if err != nil {
+		return "", 0, err
+	}
+	if s != nil {
+		var owner string
+		var permissions int
+		err := client.sftpRequest(ctx, s, "stat", path, func(s *sftp.Client) (err error) {
+			owner, permissions, err = sftpGetDetails(ctx, client, s, path)
+			return err
+		})
+		return owner, permissions, err
+	}
+	command := shellCommand("ls", "-ld", path)
+	stdout, _, err := runCommand(ctx, client, false, command, "")
 	if err != nil {
+		// ls fails the same way for missing files as for ones it can't access.
+		if commandExitStatus(err) > 0 {
+			if exists, existsErr := fileExists(ctx, client, path); existsErr == nil && !exists {
+				return "", 0, &notFoundError{"File", "path", path}
+			}
+		}
 		return "", 0, errors.Wrap(err, fmt.Sprintf("Command failed: %s", command))
 	}
 	if stdout == "" {
-		return "", 0, fmt.Errorf("File not found with path %v", path)
+		return "", 0, &notFoundError{"File", "path", path}
 	}
 	fields := strings.Fields(stdout)
 	permissions, user, group := parsePermissionString(fields[0]), fields[2], fields[3]
@@ -162,33 +232,58 @@ func getDetails(client *Client, path string) (string, int, error) {
 	return fmt.Sprintf("%s:%s", user, group), permissions, nil
 }
 
-func readFile(client *Client, path string) (string, error) {
-	command := fmt.Sprintf("cat %s", path)
-	stdout, _, err := runCommand(client, false, command, "")
+// fileExists tells whether there's a file at path, counting dangling symlinks.
+func fileExists(ctx context.Context, client *Client, path string) (bool, error) {
+	command := fmt.Sprintf("%s || %s", shellCommand("test", "-e", path), shellCommand("test", "-L", path))
+	_, _, err := runCommand(ctx, client, false, command, "")
+	if commandExitStatus(err) == 1 {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, fmt.Sprintf("Command failed: %s", command))
+	}
+	return true, nil
+}
+
+func readFile(ctx context.Context, client *Client, path string) (string, error) {
+	s, err := client.sftpClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	if s != nil {
+		var content string
+		err := client.sftpRequest(ctx, s, "read", path, func(s *sftp.Client) (err error) {
+			content, err = sftpReadFile(s, path)
+			return err
+		})
+		return content, errors.Wrap(err, fmt.Sprintf("SFTP read failed: %s", path))
+	}
+	command := shellCommand("cat", path)
+	stdout, _, err := runCommand(ctx, client, false, command, "")
 	if err != nil {
 		return "", errors.Wrap(err, fmt.Sprintf("Command failed: %s", command))
 	}
 	return stdout, nil
 }
 
-func fileResourceReadWrapper(isFolder bool) func(*schema.ResourceData, interface{}) error {
-	return func(d *schema.ResourceData, m interface{}) error {
-		client := m.(*Client)
+func fileResourceReadWrapper(isFolder bool) schema.ReadContextFunc {
+	return func(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+		client := m.(*Client).as(d.Get("run_as").(string))
 		id := d.Id()
 
-		owner, permissions, err := getDetails(client, id)
+		owner, permissions, err := getDetails(ctx, client, id)
 		if err != nil {
-			if strings.Contains(err.Error(), "File not found with path") {
+			if isNotFound(err) {
 				d.SetId("")
 				return nil
 			}
-			return errors.Wrap(err, "Unable to ls the file")
+			return diag.FromErr(errors.Wrap(err, "Unable to ls the file"))
 		}
 
 		if !isFolder {
-			content, err := readFile(client, id)
+			content, err := readFile(ctx, client, id)
 			if err != nil {
-				return errors.Wrap(err, "Unable to read the file")
+				return diag.FromErr(errors.Wrap(err, "Unable to read the file"))
 			}
 			d.Set("content", content)
 		}
@@ -199,77 +294,90 @@ func fileResourceReadWrapper(isFolder bool) func(*schema.ResourceData, interface
 	}
 }
 
-func moveFile(client *Client, oldPath string, newPath string) error {
-	command := fmt.Sprintf("mv %s %s", oldPath, newPath)
-	_, _, err := runCommand(client, false, command, "")
+func moveFile(ctx context.Context, client *Client, oldPath string, newPath string) error {
+	for _, path := range []string{oldPath, newPath} {
+		if err := client.checkPath(path); err != nil {
+			return err
+		}
+	}
+	command := shellCommand("mv", oldPath, newPath)
+	_, _, err := runMutatingCommand(ctx, client, false, command, "")
 	if err != nil {
 		return errors.Wrap(err, fmt.Sprintf("Command failed: %s", command))
 	}
 	return nil
 }
 
-func fileResourceUpdateWrapper(isFolder bool) func(*schema.ResourceData, interface{}) error {
-	return func(d *schema.ResourceData, m interface{}) error {
+func fileResourceUpdateWrapper(isFolder bool) schema.UpdateContextFunc {
+	return func(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 		client := m.(*Client)
 
 		path := d.Get("path").(string)
 		owner := d.Get("owner").(string)
 		permissions := d.Get("permissions").(int)
 
+		fileClient := client.as(d.Get("run_as").(string))
+
 		oldPath := d.Id()
-		oldOwner, oldPermissions, err := getDetails(client, oldPath)
+		oldOwner, oldPermissions, err := getDetails(ctx, fileClient, oldPath)
 		if err != nil {
-			return errors.Wrap(err, "Unable to ls the file")
+			return diag.FromErr(errors.Wrap(err, "Unable to ls the file"))
 		}
 
 		if !isFolder {
 			content := d.Get("content").(string)
-			oldContent, err := readFile(client, oldPath)
+			oldContent, err := readFile(ctx, fileClient, oldPath)
 			if err != nil {
-				return errors.Wrap(err, "Unable to read the file")
+				return diag.FromErr(errors.Wrap(err, "Unable to read the file"))
 			}
 			if oldContent != content {
-				if err := writeContent(client, oldPath, content); err != nil {
-					return errors.Wrap(err, "Couldn't rewrite content")
+				if err := writeContent(ctx, fileClient, oldPath, content); err != nil {
+					return diag.FromErr(errors.Wrap(err, "Couldn't rewrite content"))
 				}
 			}
 		}
 
 		if oldPath != path {
-			if err := moveFile(client, oldPath, path); err != nil {
-				return errors.Wrap(err, "Couldn't mv file")
+			if err := moveFile(ctx, fileClient, oldPath, path); err != nil {
+				return diag.FromErr(errors.Wrap(err, "Couldn't mv file"))
 			}
 			d.SetId(path)
 		}
 
 		if oldOwner != owner {
-			if err := applyOwner(client, path, owner); err != nil {
-				return errors.Wrap(err, "Couldn't apply owner")
+			if err := applyOwner(ctx, client, path, owner); err != nil {
+				return diag.FromErr(errors.Wrap(err, "Couldn't apply owner"))
 			}
 		}
 
 		if oldPermissions != permissions {
-			if err := applyPermissions(client, path, permissions); err != nil {
-				return errors.Wrap(err, "Couldn't apply permissions")
+			if err := applyPermissions(ctx, client, path, permissions); err != nil {
+				return diag.FromErr(errors.Wrap(err, "Couldn't apply permissions"))
 			}
 		}
 
-		return fileResourceReadWrapper(isFolder)(d, m)
+		if client.exporting() {
+			return nil
+		}
+		return fileResourceReadWrapper(isFolder)(ctx, d, m)
 	}
 }
 
-func deleteFile(client *Client, path string) error {
-	command := fmt.Sprintf("rm -rf %s", path)
-	_, _, err := runCommand(client, false, command, "")
+func deleteFile(ctx context.Context, client *Client, path string) error {
+	if err := client.checkPath(path); err != nil {
+		return err
+	}
+	command := shellCommand("rm", "-rf", path)
+	_, _, err := runMutatingCommand(ctx, client, false, command, "")
 	if err != nil {
 		return errors.Wrap(err, fmt.Sprintf("Command failed: %s", command))
 	}
 	return nil
 }
 
-func fileResourceDelete(d *schema.ResourceData, m interface{}) error {
-	client := m.(*Client)
+func fileResourceDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	client := m.(*Client).as(d.Get("run_as").(string))
 	id := d.Id()
 
-	return deleteFile(client, id)
+	return diag.FromErr(deleteFile(ctx, client, id))
 }
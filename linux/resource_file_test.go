@@ -1,8 +1,10 @@
 package linux
 
 import (
+	"context"
 	"testing"
 
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
 )
 
@@ -147,6 +149,141 @@ func TestAccFileUpdation(t *testing.T) {
 	})
 }
 
+func TestFileCreate(t *testing.T) {
+	client, executor := testFakeClient(map[string]fakeResult{
+		"ls -ld /etc/testfile": {stdout: "-rw-r--r-- 1 testuser testgroup 11 Jan  1 00:00 /etc/testfile\n"},
+		"cat /etc/testfile":    {stdout: "testcontent"},
+	})
+	d := schema.TestResourceDataRaw(t, fileResource().Schema, map[string]interface{}{
+		"path":        "/etc/testfile",
+		"owner":       "testuser:testgroup",
+		"permissions": 644,
+		"content":     "testcontent",
+	})
+
+	if diags := fileResourceCreateWrapper(false)(context.Background(), d, client); diags.HasError() {
+		t.Fatalf("Unable to create file: %v", diags)
+	}
+	assertCommands(t, executor,
+		"touch /etc/testfile",
+		"chown -- testuser:testgroup /etc/testfile",
+		"chmod 644 /etc/testfile",
+		"cat > /etc/testfile",
+		"ls -ld /etc/testfile",
+		"cat /etc/testfile",
+	)
+	if executor.stdins[3] != "testcontent" {
+		t.Errorf("Content should be written through stdin: %q", executor.stdins[3])
+	}
+	if d.Id() != "/etc/testfile" {
+		t.Errorf("ID should be the path: %s", d.Id())
+	}
+}
+
+func TestFileRead(t *testing.T) {
+	client, _ := testFakeClient(map[string]fakeResult{
+		"ls -ld /etc/testfile": {stdout: "-rwxr-x--- 1 root wheel 5 Jan  1 00:00 /etc/testfile\n"},
+		"cat /etc/testfile":    {stdout: "hello"},
+	})
+	d := schema.TestResourceDataRaw(t, fileResource().Schema, map[string]interface{}{"path": "/etc/testfile"})
+	d.SetId("/etc/testfile")
+
+	if diags := fileResourceReadWrapper(false)(context.Background(), d, client); diags.HasError() {
+		t.Fatalf("Unable to read file: %v", diags)
+	}
+	if d.Get("owner") != "root:wheel" || d.Get("permissions") != 750 || d.Get("content") != "hello" {
+		t.Errorf("File attributes not read correctly: %v %v %v", d.Get("owner"), d.Get("permissions"), d.Get("content"))
+	}
+}
+
+func TestFileReadMissing(t *testing.T) {
+	client, _ := testFakeClient(map[string]fakeResult{
+		"ls -ld /etc/testfile":                           {stderr: "No such file or directory", exitStatus: 2},
+		"test -e /etc/testfile || test -L /etc/testfile": {exitStatus: 1},
+	})
+	d := schema.TestResourceDataRaw(t, fileResource().Schema, map[string]interface{}{"path": "/etc/testfile"})
+	d.SetId("/etc/testfile")
+
+	if diags := fileResourceReadWrapper(false)(context.Background(), d, client); diags.HasError() {
+		t.Fatalf("Missing file shouldn't be an error: %v", diags)
+	}
+	if d.Id() != "" {
+		t.Errorf("Missing file should be removed from the state")
+	}
+}
+
+func TestFileReadFailure(t *testing.T) {
+	client, _ := testFakeClient(map[string]fakeResult{
+		"ls -ld /etc/testfile": {stderr: "Permission denied", exitStatus: 2},
+	})
+	d := schema.TestResourceDataRaw(t, fileResource().Schema, map[string]interface{}{"path": "/etc/testfile"})
+	d.SetId("/etc/testfile")
+
+	if diags := fileResourceReadWrapper(false)(context.Background(), d, client); !diags.HasError() {
+		t.Errorf("File that can't be read should be an error")
+	}
+	if d.Id() != "/etc/testfile" {
+		t.Errorf("File that can't be read shouldn't be removed from the state")
+	}
+}
+
+func TestFileRollback(t *testing.T) {
+	client, executor := testFakeClient(map[string]fakeResult{
+		"chown -- nobody:nogroup /etc/testfile": {stderr: "chown: invalid user", exitStatus: 1},
+	})
+	d := schema.TestResourceDataRaw(t, fileResource().Schema, map[string]interface{}{
+		"path":  "/etc/testfile",
+		"owner": "nobody:nogroup",
+	})
+
+	if diags := fileResourceCreateWrapper(false)(context.Background(), d, client); !diags.HasError() {
+		t.Fatalf("Failing chown should fail the creation")
+	}
+	assertCommands(t, executor,
+		"touch /etc/testfile",
+		"chown -- nobody:nogroup /etc/testfile",
+		"rm -rf /etc/testfile",
+	)
+}
+
+func TestApplyOwnerIsNotAnOption(t *testing.T) {
+	client, executor := testFakeClient(nil)
+	if err := applyOwner(context.Background(), client, "/etc/testfile", "--from=root:x"); err != nil {
+		t.Fatal(err)
+	}
+	assertCommands(t, executor, "chown -- --from=root:x /etc/testfile")
+}
+
+func TestFileCreateAsUser(t *testing.T) {
+	client, executor := testFakeClient(map[string]fakeResult{
+		"sudo -u svc sh -c 'ls -ld /home/svc/testfile'": {stdout: "-rw------- 1 svc svc 11 Jan  1 00:00 /home/svc/testfile\n"},
+		"sudo -u svc sh -c 'cat /home/svc/testfile'":    {stdout: "testcontent"},
+	})
+	d := schema.TestResourceDataRaw(t, fileResource().Schema, map[string]interface{}{
+		"path":    "/home/svc/testfile",
+		"content": "testcontent",
+		"run_as":  "svc",
+	})
+
+	if diags := fileResourceCreateWrapper(false)(context.Background(), d, client); diags.HasError() {
+		t.Fatalf("Unable to create file: %v", diags)
+	}
+	assertCommands(t, executor,
+		"sudo -u svc sh -c 'touch /home/svc/testfile'",
+		"sudo -u svc sh -c 'cat > /home/svc/testfile'",
+		"sudo -u svc sh -c 'ls -ld /home/svc/testfile'",
+		"sudo -u svc sh -c 'cat /home/svc/testfile'",
+	)
+	if d.Get("owner") != "svc:svc" || d.Get("content") != "testcontent" {
+		t.Errorf("File attributes not read correctly: %v %v", d.Get("owner"), d.Get("content"))
+	}
+
+	client.escalation.Method = escalationNone
+	if diags := fileResourceDelete(context.Background(), d, client); !diags.HasError() {
+		t.Errorf("run_as without an escalation method should fail")
+	}
+}
+
 const fileCreationConfig = `
 resource "linux_file" "testfile" {
   path = "/etc/testfile"
@@ -6,12 +6,15 @@ import (
 
 func folderResource() *schema.Resource {
 	return &schema.Resource{
-		Create: fileResourceCreateWrapper(true),
-		Read:   fileResourceReadWrapper(true),
-		Update: fileResourceUpdateWrapper(true),
-		Delete: fileResourceDelete,
+		CreateContext: fileResourceCreateWrapper(true),
+		ReadContext:   fileResourceReadWrapper(true),
+		UpdateContext: fileResourceUpdateWrapper(true),
+		DeleteContext: fileResourceDelete,
+		CustomizeDiff: checkPlannedPath,
+		Timeouts:      resourceTimeouts(),
 
 		Schema: map[string]*schema.Schema{
+			"environment": environmentSchema("Environment variables to run the commands for the folder with, on top of the provider's"),
 			"path": {
 				Type:         schema.TypeString,
 				Required:     true,
@@ -28,6 +31,12 @@ func folderResource() *schema.Resource {
 				Optional: true,
 				Computed: true,
 			},
+			"run_as": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "",
+				Description: "The user to create, read, write, move and delete the folder as",
+			},
 		},
 	}
 }
@@ -1,26 +1,30 @@
 package linux
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"strconv"
 	"strings"
 
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/pkg/errors"
 )
 
 func groupResource() *schema.Resource {
 	return &schema.Resource{
-		Create: groupResourceCreate,
-		Read:   groupResourceRead,
-		Update: groupResourceUpdate,
-		Delete: groupResourceDelete,
+		CreateContext: groupResourceCreate,
+		ReadContext:   groupResourceRead,
+		UpdateContext: groupResourceUpdate,
+		DeleteContext: groupResourceDelete,
+		Timeouts:      resourceTimeouts(),
 		Importer: &schema.ResourceImporter{
 			StateContext: schema.ImportStatePassthroughContext,
 		},
 
 		Schema: map[string]*schema.Schema{
+			"environment": environmentSchema("Environment variables to run the commands for the group with, on top of the provider's"),
 			"name": {
 				Type:     schema.TypeString,
 				Required: true,
@@ -40,53 +44,60 @@ func groupResource() *schema.Resource {
 	}
 }
 
-func groupResourceCreate(d *schema.ResourceData, m interface{}) error {
+func groupResourceCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	client := m.(*Client)
 	name := d.Get("name").(string)
 	gid := d.Get("gid").(int)
 	system := d.Get("system").(bool)
 
-	err := createGroup(client, name, gid, system)
+	err := createGroup(ctx, client, name, gid, system)
 	if err != nil {
-		return errors.Wrap(err, "Couldn't create group")
+		return diag.FromErr(errors.Wrap(err, "Couldn't create group"))
+	}
+	if client.exporting() {
+		d.SetId(exportedID(gid, name))
+		return nil
 	}
 
-	gid, err = getGroupId(client, name)
+	gid, err = getGroupId(ctx, client, name)
 	if err != nil {
-		return errors.Wrap(err, "Couldn't get gid")
+		return diag.FromErr(errors.Wrap(err, "Couldn't get gid"))
 	}
 
 	d.Set("gid", gid)
 
 	d.SetId(fmt.Sprintf("%v", gid))
-	return groupResourceRead(d, m)
+	return groupResourceRead(ctx, d, m)
 }
 
-func createGroup(client *Client, name string, gid int, system bool) error {
-	command := "/usr/sbin/groupadd"
+func createGroup(ctx context.Context, client *Client, name string, gid int, system bool) error {
+	args := []string{"/usr/sbin/groupadd"}
 
 	if gid > 0 {
-		command = fmt.Sprintf("%s --gid %d", command, gid)
+		args = append(args, "--gid", strconv.Itoa(gid))
 	}
 	if system {
-		command = fmt.Sprintf("%s --system", command)
+		args = append(args, "--system")
 	}
-	command = fmt.Sprintf("%s %s", command, name)
-	_, _, err := runCommand(client, true, command, "")
+	command := shellCommand(append(args, "--", name)...)
+	_, _, err := runMutatingCommand(ctx, client, true, command, "")
 	if err != nil {
 		return errors.Wrap(err, fmt.Sprintf("Command failed: %s", command))
 	}
 	return nil
 }
 
-func getGroupId(client *Client, name string) (int, error) {
-	command := fmt.Sprintf("getent group %s", name)
-	stdout, _, err := runCommand(client, false, command, "")
+func getGroupId(ctx context.Context, client *Client, name string) (int, error) {
+	command := shellCommand("getent", "group", name)
+	stdout, _, err := runCommand(ctx, client, false, command, "")
+	if commandExitStatus(err) == getentNotFound {
+		stdout, err = "", nil
+	}
 	if err != nil {
 		return 0, errors.Wrap(err, fmt.Sprintf("Command failed: %s", command))
 	}
 	if stdout == "" {
-		return 0, fmt.Errorf("Group not found with name %v", name)
+		return 0, &notFoundError{"Group", "name", name}
 	}
 	gid, err := strconv.Atoi(strings.Split(stdout, ":")[2])
 	if err != nil {
@@ -95,77 +106,86 @@ func getGroupId(client *Client, name string) (int, error) {
 	return gid, nil
 }
 
-func getGroupName(client *Client, gid int) (string, error) {
+func getGroupName(ctx context.Context, client *Client, gid int) (string, error) {
 	command := fmt.Sprintf("getent group %d", gid)
-	stdout, _, err := runCommand(client, false, command, "")
+	stdout, _, err := runCommand(ctx, client, false, command, "")
+	if commandExitStatus(err) == getentNotFound {
+		stdout, err = "", nil
+	}
 	if err != nil {
 		return "", errors.Wrap(err, fmt.Sprintf("Command failed: %s", command))
 	}
 	if stdout == "" {
-		return "", fmt.Errorf("Group not found with id %v", gid)
+		return "", &notFoundError{"Group", "id", gid}
 	}
 	name := strings.Split(stdout, ":")[0]
 	return name, nil
 }
 
-func groupResourceRead(d *schema.ResourceData, m interface{}) error {
+func groupResourceRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	client := m.(*Client)
 	gid, err := strconv.Atoi(d.Id())
 	if err != nil {
-		return errors.Wrap(err, "ID stored is not int")
+		return diag.FromErr(errors.Wrap(err, "ID stored is not int"))
 	}
-	name, err := getGroupName(client, gid)
-	if err != nil {
+	name, err := getGroupName(ctx, client, gid)
+	if isNotFound(err) {
 		log.Printf("%v", err)
 		log.Printf("Error getting group name, will recreate it")
 		d.SetId("")
 		return nil
 	}
+	if err != nil {
+		return diag.FromErr(errors.Wrap(err, "Failed to get group name"))
+	}
 	d.Set("name", name)
 	return nil
 }
 
-func groupResourceUpdate(d *schema.ResourceData, m interface{}) error {
+func groupResourceUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	client := m.(*Client)
 	gid, err := strconv.Atoi(d.Id())
 	if err != nil {
-		return errors.Wrap(err, "ID stored is not int")
+		return diag.FromErr(errors.Wrap(err, "ID stored is not int"))
 	}
 	name := d.Get("name").(string)
-	oldname, err := getGroupName(client, gid)
+	oldname, err := getGroupName(ctx, client, gid)
 	if err != nil {
-		return errors.Wrap(err, "Failed to get group name")
+		return diag.FromErr(errors.Wrap(err, "Failed to get group name"))
 	}
 
 	if oldname != name {
-		command := fmt.Sprintf("/usr/sbin/groupmod %s -n %s", oldname, name)
-		_, _, err = runCommand(client, true, command, "")
+		command := shellCommand("/usr/sbin/groupmod", "-n", name, "--", oldname)
+		_, _, err = runMutatingCommand(ctx, client, true, command, "")
 		if err != nil {
-			return errors.Wrap(err, fmt.Sprintf("Command failed: %s", command))
+			return diag.FromErr(errors.Wrap(err, fmt.Sprintf("Command failed: %s", command)))
 		}
 	}
-	return groupResourceRead(d, m)
+	if client.exporting() {
+		return nil
+	}
+	return groupResourceRead(ctx, d, m)
 }
 
-func deleteGroup(client *Client, name string) error {
-	command := fmt.Sprintf("/usr/sbin/groupdel %s", name)
-	_, _, err := runCommand(client, true, command, "")
+func deleteGroup(ctx context.Context, client *Client, name string) error {
+	command := shellCommand("/usr/sbin/groupdel", "--", name)
+	_, _, err := runMutatingCommand(ctx, client, true, command, "")
 	if err != nil {
 		return errors.Wrap(err, fmt.Sprintf("Command failed: %s", command))
 	}
 	return nil
 }
 
-func groupResourceDelete(d *schema.ResourceData, m interface{}) error {
+func groupResourceDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	client := m.(*Client)
 	gid, err := strconv.Atoi(d.Id())
 	if err != nil {
-		return errors.Wrap(err, "ID stored is not int")
+		return diag.FromErr(errors.Wrap(err, "ID stored is not int"))
 	}
-	name, err := getGroupName(client, gid)
+	name, err := getGroupName(ctx, client, gid)
 	if err != nil {
-		return errors.Wrap(err, "Failed to get group name")
+		return diag.FromErr(errors.Wrap(err, "Failed to get group name"))
 	}
 
-	return deleteGroup(client, name)
+	return diag.FromErr(deleteGroup(ctx, client, name))
 }
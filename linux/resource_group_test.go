@@ -1,9 +1,11 @@
 package linux
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
 	"github.com/hashicorp/terraform-plugin-testing/terraform"
 )
@@ -71,7 +73,7 @@ func TestAccGroupWithGIDCreation(t *testing.T) {
 func testAccCheckGID(groupname string, check func(int) error) resource.TestCheckFunc {
 	return func(s *terraform.State) error {
 		client := testAccProvider.Meta().(*Client)
-		gid, err := getGroupId(client, groupname)
+		gid, err := getGroupId(context.Background(), client, groupname)
 		if err != nil {
 			return err
 		}
@@ -111,6 +113,53 @@ func TestAccGroupUpdation(t *testing.T) {
 	})
 }
 
+func TestGroupCreate(t *testing.T) {
+	client, executor := testFakeClient(map[string]fakeResult{
+		"getent group testgroup": {stdout: "testgroup:x:999:\n"},
+		"getent group 999":       {stdout: "testgroup:x:999:\n"},
+	})
+	d := schema.TestResourceDataRaw(t, groupResource().Schema, map[string]interface{}{
+		"name":   "testgroup",
+		"system": true,
+	})
+
+	if diags := groupResourceCreate(context.Background(), d, client); diags.HasError() {
+		t.Fatalf("Unable to create group: %v", diags)
+	}
+	assertCommands(t, executor,
+		"/usr/sbin/groupadd --system -- testgroup",
+		"getent group testgroup",
+		"getent group 999",
+	)
+	if d.Id() != "999" || d.Get("gid") != 999 {
+		t.Errorf("ID should be the gid: %s", d.Id())
+	}
+}
+
+func TestGroupUpdate(t *testing.T) {
+	client, executor := testFakeClient(map[string]fakeResult{
+		"getent group 999": {stdout: "testgroup:x:999:\n"},
+	})
+	d := schema.TestResourceDataRaw(t, groupResource().Schema, map[string]interface{}{"name": "renamed"})
+	d.SetId("999")
+
+	groupResourceUpdate(context.Background(), d, client)
+	assertCommands(t, executor,
+		"getent group 999",
+		"/usr/sbin/groupmod -n renamed -- testgroup",
+		"getent group 999",
+	)
+}
+
+func TestGroupCreateHostileName(t *testing.T) {
+	client, executor := testFakeClient(nil)
+
+	if err := createGroup(context.Background(), client, "$(reboot)", 0, false); err != nil {
+		t.Fatalf("Unable to create group: %v", err)
+	}
+	assertCommands(t, executor, "/usr/sbin/groupadd -- '$(reboot)'")
+}
+
 const groupConfig = `
 resource "linux_group" "testgroup" {
 	name = "testgroup"
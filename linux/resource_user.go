@@ -1,25 +1,29 @@
 package linux
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"strings"
 
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/pkg/errors"
 )
 
 func userResource() *schema.Resource {
 	return &schema.Resource{
-		Create: userResourceCreate,
-		Read:   userResourceRead,
-		Update: userResourceUpdate,
-		Delete: userResourceDelete,
+		CreateContext: userResourceCreate,
+		ReadContext:   userResourceRead,
+		UpdateContext: userResourceUpdate,
+		DeleteContext: userResourceDelete,
+		Timeouts:      resourceTimeouts(),
 		Importer: &schema.ResourceImporter{
 			StateContext: schema.ImportStatePassthroughContext,
 		},
 
 		Schema: map[string]*schema.Schema{
+			"environment": environmentSchema("Environment variables to run the commands for the user with, on top of the provider's"),
 			"name": &schema.Schema{
 				Type:     schema.TypeString,
 				Required: true,
@@ -81,7 +85,7 @@ func userResource() *schema.Resource {
 	}
 }
 
-func userResourceCreate(d *schema.ResourceData, m interface{}) error {
+func userResourceCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	client := m.(*Client)
 	name := d.Get("name").(string)
 	uid := d.Get("uid").(int)
@@ -97,67 +101,71 @@ func userResourceCreate(d *schema.ResourceData, m interface{}) error {
 		groupsList[i] = group.(string)
 	}
 
-	err := createUser(client, name, uid, gid, system, comment, home, create_home, shell, groupsList)
+	err := createUser(ctx, client, name, uid, gid, system, comment, home, create_home, shell, groupsList)
 	if err != nil {
-		return errors.Wrap(err, "Couldn't create user")
+		return diag.FromErr(errors.Wrap(err, "Couldn't create user"))
+	}
+	if client.exporting() {
+		d.SetId(exportedID(uid, name))
+		return nil
 	}
 
-	uid, err = getUserId(client, name)
+	uid, err = getUserId(ctx, client, name)
 	if err != nil {
-		return errors.Wrap(err, "Couldn't get uid")
+		return diag.FromErr(errors.Wrap(err, "Couldn't get uid"))
 	}
 
 	d.Set("uid", uid)
 
 	d.SetId(fmt.Sprintf("%v", uid))
-	return userResourceRead(d, m)
+	return userResourceRead(ctx, d, m)
 }
 
-func createUser(client *Client, name string, uid int, gid int, system bool, comment string, home string, create_home bool, shell string, groups []string) error {
-	command := "/usr/sbin/useradd"
+func createUser(ctx context.Context, client *Client, name string, uid int, gid int, system bool, comment string, home string, create_home bool, shell string, groups []string) error {
+	args := []string{"/usr/sbin/useradd"}
 
 	if len(home) > 0 {
-		command = fmt.Sprintf("%s --home-dir %s", command, home)
+		args = append(args, "--home-dir", home)
 	} else {
-		command = fmt.Sprintf("%s --home-dir /home/%s", command, name)
+		args = append(args, "--home-dir", "/home/"+name)
 	}
 	if create_home {
-		command = fmt.Sprintf("%s --create-home", command)
+		args = append(args, "--create-home")
 	}
 	if len(comment) > 0 {
-		command = fmt.Sprintf("%s --comment '%s'", command, comment)
+		args = append(args, "--comment", comment)
 	}
 	if len(shell) > 0 {
-		command = fmt.Sprintf("%s --shell %s", command, shell)
+		args = append(args, "--shell", shell)
 	}
 	if uid > 0 {
-		command = fmt.Sprintf("%s --uid %d", command, uid)
+		args = append(args, "--uid", strconv.Itoa(uid))
 	}
 	if gid > 0 {
-		command = fmt.Sprintf("%s --gid %d", command, gid)
+		args = append(args, "--gid", strconv.Itoa(gid))
 	}
 	if len(groups) > 0 {
-		command = fmt.Sprintf("%s --groups %s", command, strings.Join(groups, ","))
+		args = append(args, "--groups", strings.Join(groups, ","))
 	}
 	if system {
-		command = fmt.Sprintf("%s --system", command)
+		args = append(args, "--system")
 	}
-	command = fmt.Sprintf("%s %s", command, name)
-	_, _, err := runCommand(client, true, command, "")
+	command := shellCommand(append(args, "--", name)...)
+	_, _, err := runMutatingCommand(ctx, client, true, command, "")
 	if err != nil {
 		return errors.Wrap(err, fmt.Sprintf("Command failed: %s", command))
 	}
 	return nil
 }
 
-func getUserId(client *Client, name string) (int, error) {
-	command := fmt.Sprintf("id --user %s", name)
-	stdout, _, err := runCommand(client, false, command, "")
+func getUserId(ctx context.Context, client *Client, name string) (int, error) {
+	command := shellCommand("id", "--user", name)
+	stdout, _, err := runCommand(ctx, client, false, command, "")
 	if err != nil {
 		return 0, errors.Wrap(err, fmt.Sprintf("Command failed: %s", command))
 	}
 	if stdout == "" {
-		return 0, fmt.Errorf("User not found with name %v", name)
+		return 0, &notFoundError{"User", "name", name}
 	}
 	uid, err := strconv.Atoi(strings.TrimSpace(stdout))
 	if err != nil {
@@ -166,27 +174,33 @@ func getUserId(client *Client, name string) (int, error) {
 	return uid, nil
 }
 
-func getUserFromID(client *Client, uid int) ([]string, error) {
+func getUserFromID(ctx context.Context, client *Client, uid int) ([]string, error) {
 	command := fmt.Sprintf("getent passwd %d", uid)
-	stdout, _, err := runCommand(client, false, command, "")
+	stdout, _, err := runCommand(ctx, client, false, command, "")
+	if commandExitStatus(err) == getentNotFound {
+		stdout, err = "", nil
+	}
 	if err != nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("Command failed: %s", command))
 	}
 	if stdout == "" {
-		return nil, fmt.Errorf("User not found with id %v", uid)
+		return nil, &notFoundError{"User", "id", uid}
 	}
 	data := strings.Split(strings.TrimSpace(stdout), ":")
 	return data, nil
 }
 
-func getUserFromName(client *Client, name string) ([]string, error) {
-	command := fmt.Sprintf("getent passwd %s", name)
-	stdout, _, err := runCommand(client, false, command, "")
+func getUserFromName(ctx context.Context, client *Client, name string) ([]string, error) {
+	command := shellCommand("getent", "passwd", name)
+	stdout, _, err := runCommand(ctx, client, false, command, "")
+	if commandExitStatus(err) == getentNotFound {
+		stdout, err = "", nil
+	}
 	if err != nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("Command failed: %s", command))
 	}
 	if stdout == "" {
-		return nil, fmt.Errorf("User not found with name %v", name)
+		return nil, &notFoundError{"User", "name", name}
 	}
 	data := strings.Split(strings.TrimSpace(stdout), ":")
 	return data, nil
@@ -200,72 +214,75 @@ func getGroupIdForUser(_ *Client, details []string) (int, error) {
 	return uid, nil
 }
 
-func getUserGroups(client *Client, name string) ([]string, error) {
-	command := fmt.Sprintf("id --name --groups %s", name)
-	stdout, _, err := runCommand(client, false, command, "")
+func getUserGroups(ctx context.Context, client *Client, name string) ([]string, error) {
+	command := shellCommand("id", "--name", "--groups", name)
+	stdout, _, err := runCommand(ctx, client, false, command, "")
 	if err != nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("Command failed: %s", command))
 	}
 	if stdout == "" {
-		return nil, fmt.Errorf("User not found with name %v", name)
+		return nil, &notFoundError{"User", "name", name}
 	}
 	return strings.Split(strings.TrimSpace(stdout), " "), nil
 }
 
-func userResourceRead(d *schema.ResourceData, m interface{}) error {
+func userResourceRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	client := m.(*Client)
 	uid, err := strconv.Atoi(d.Id())
 	if err != nil {
-		return errors.Wrap(err, "ID stored is not int")
+		return diag.FromErr(errors.Wrap(err, "ID stored is not int"))
 	}
-	details, err := getUserFromID(client, uid)
-	if err != nil {
+	details, err := getUserFromID(ctx, client, uid)
+	if isNotFound(err) {
 		d.SetId("")
 		return nil
 	}
+	if err != nil {
+		return diag.FromErr(errors.Wrap(err, "Failed to get user"))
+	}
 	d.Set("name", details[0])
 	gid, err := getGroupIdForUser(client, details)
 	if err != nil {
-		return errors.Wrap(err, "Couldn't find group for user")
+		return diag.FromErr(errors.Wrap(err, "Couldn't find group for user"))
 	}
 	d.Set("gid", gid)
 	d.Set("comment", details[4])
 	d.Set("home", details[5])
 	d.Set("shell", details[6])
-	groups, err := getUserGroups(client, details[0])
+	groups, err := getUserGroups(ctx, client, details[0])
 	if err != nil {
-		return errors.Wrap(err, "Couldn't find group for user")
+		return diag.FromErr(errors.Wrap(err, "Couldn't find group for user"))
 	}
 	d.Set("groups", groups)
 	return nil
 }
 
-func userResourceUpdate(d *schema.ResourceData, m interface{}) error {
+func userResourceUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	client := m.(*Client)
 	uid, err := strconv.Atoi(d.Id())
 	if err != nil {
-		return errors.Wrap(err, "ID stored is not int")
+		return diag.FromErr(errors.Wrap(err, "ID stored is not int"))
 	}
-	old, err := getUserFromID(client, uid)
+	old, err := getUserFromID(ctx, client, uid)
 	if err != nil {
-		return errors.Wrap(err, "Failed to get user name")
+		return diag.FromErr(errors.Wrap(err, "Failed to get user name"))
 	}
-	command := "/usr/sbin/usermod"
+	args := []string{"/usr/sbin/usermod"}
 
 	if d.HasChange("name") {
-		command = fmt.Sprintf("%s --login %s", command, d.Get("name").(string))
+		args = append(args, "--login", d.Get("name").(string))
 	}
 	if d.HasChange("gid") {
-		command = fmt.Sprintf("%s --gid %d", command, d.Get("gid").(int))
+		args = append(args, "--gid", strconv.Itoa(d.Get("gid").(int)))
 	}
 	if d.HasChange("home") {
-		command = fmt.Sprintf("%s --move-home --home '%s'", command, d.Get("home").(string))
+		args = append(args, "--move-home", "--home", d.Get("home").(string))
 	}
 	if d.HasChange("shell") {
-		command = fmt.Sprintf("%s --shell %s", command, d.Get("shell").(string))
+		args = append(args, "--shell", d.Get("shell").(string))
 	}
 	if d.HasChange("comment") {
-		command = fmt.Sprintf("%s --comment '%s'", command, d.Get("comment").(string))
+		args = append(args, "--comment", d.Get("comment").(string))
 	}
 	if d.HasChange("groups") {
 		groups := d.Get("groups").(*schema.Set).List()
@@ -273,33 +290,36 @@ func userResourceUpdate(d *schema.ResourceData, m interface{}) error {
 		for i, group := range groups {
 			groupsList[i] = group.(string)
 		}
-		command = fmt.Sprintf("%s --groups %s", command, strings.Join(groupsList, ","))
+		args = append(args, "--groups", strings.Join(groupsList, ","))
 	}
 
-	command = fmt.Sprintf("%s %s", command, old[0])
-	_, _, err = runCommand(client, true, command, "")
+	command := shellCommand(append(args, "--", old[0])...)
+	_, _, err = runMutatingCommand(ctx, client, true, command, "")
 	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("Command failed: %s", command))
+		return diag.FromErr(errors.Wrap(err, fmt.Sprintf("Command failed: %s", command)))
+	}
+	if client.exporting() {
+		return nil
 	}
 
-	return userResourceRead(d, m)
+	return userResourceRead(ctx, d, m)
 }
 
-func userResourceDelete(d *schema.ResourceData, m interface{}) error {
+func userResourceDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	client := m.(*Client)
 	uid, err := strconv.Atoi(d.Id())
 	if err != nil {
-		return errors.Wrap(err, "ID stored is not int")
+		return diag.FromErr(errors.Wrap(err, "ID stored is not int"))
 	}
-	details, err := getUserFromID(client, uid)
+	details, err := getUserFromID(ctx, client, uid)
 	if err != nil {
-		return errors.Wrap(err, "Failed to get user name")
+		return diag.FromErr(errors.Wrap(err, "Failed to get user name"))
 	}
 
-	command := fmt.Sprintf("/usr/sbin/userdel %s", details[0])
-	_, _, err = runCommand(client, true, command, "")
+	command := shellCommand("/usr/sbin/userdel", "--", details[0])
+	_, _, err = runMutatingCommand(ctx, client, true, command, "")
 	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("Command failed: %s", command))
+		return diag.FromErr(errors.Wrap(err, fmt.Sprintf("Command failed: %s", command)))
 	}
 	return nil
 }
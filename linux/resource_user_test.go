@@ -1,9 +1,11 @@
 package linux
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
 	"github.com/hashicorp/terraform-plugin-testing/terraform"
 )
@@ -136,7 +138,7 @@ func TestAccUserUpdation(t *testing.T) {
 		ProviderFactories: testAccProviderFactories,
 		CheckDestroy: func(*terraform.State) error {
 			client := testAccProvider.Meta().(*Client)
-			return deleteGroup(client, "testuser") // changing testuser's name leaves this group dangling
+			return deleteGroup(context.Background(), client, "testuser") // changing testuser's name leaves this group dangling
 		},
 		Steps: []resource.TestStep{
 			resource.TestStep{
@@ -191,7 +193,7 @@ func TestAccUserUpdation(t *testing.T) {
 func testAccCheckUID(username string, check func(int) error) resource.TestCheckFunc {
 	return func(s *terraform.State) error {
 		client := testAccProvider.Meta().(*Client)
-		uid, err := getUserId(client, username)
+		uid, err := getUserId(context.Background(), client, username)
 		if err != nil {
 			return err
 		}
@@ -202,7 +204,7 @@ func testAccCheckUID(username string, check func(int) error) resource.TestCheckF
 func testAccCheckGIDForUser(username string, check func(int) error) resource.TestCheckFunc {
 	return func(s *terraform.State) error {
 		client := testAccProvider.Meta().(*Client)
-		details, err := getUserFromName(client, username)
+		details, err := getUserFromName(context.Background(), client, username)
 		if err != nil {
 			return err
 		}
@@ -214,6 +216,75 @@ func testAccCheckGIDForUser(username string, check func(int) error) resource.Tes
 	}
 }
 
+func TestCreateUser(t *testing.T) {
+	client, executor := testFakeClient(nil)
+
+	if err := createUser(context.Background(), client, "testuser", 1024, 0, false, "Test User", "", true, "/bin/bash", []string{"wheel", "docker"}); err != nil {
+		t.Fatalf("Unable to create user: %v", err)
+	}
+	assertCommands(t, executor,
+		"/usr/sbin/useradd --home-dir /home/testuser --create-home --comment 'Test User' --shell /bin/bash --uid 1024 --groups wheel,docker -- testuser",
+	)
+}
+
+func TestCreateUserHostileValues(t *testing.T) {
+	client, executor := testFakeClient(nil)
+
+	if err := createUser(context.Background(), client, "-o", 0, 0, false, "O'Brien; rm -rf /", "/home/o brien", false, "", nil); err != nil {
+		t.Fatalf("Unable to create user: %v", err)
+	}
+	assertCommands(t, executor,
+		`/usr/sbin/useradd --home-dir '/home/o brien' --comment 'O'\''Brien; rm -rf /' -- -o`,
+	)
+}
+
+func TestUserRead(t *testing.T) {
+	client, _ := testFakeClient(map[string]fakeResult{
+		"getent passwd 1024":          {stdout: "testuser:x:1024:1025:Test User:/home/testuser:/bin/sh\n"},
+		"id --name --groups testuser": {stdout: "testuser wheel\n"},
+	})
+	d := schema.TestResourceDataRaw(t, userResource().Schema, map[string]interface{}{"name": "testuser"})
+	d.SetId("1024")
+
+	if diags := userResourceRead(context.Background(), d, client); diags.HasError() {
+		t.Fatalf("Unable to read user: %v", diags)
+	}
+	if d.Get("gid") != 1025 || d.Get("comment") != "Test User" || d.Get("home") != "/home/testuser" || d.Get("shell") != "/bin/sh" {
+		t.Errorf("User attributes not read correctly: %v", d.State().Attributes)
+	}
+	if groups := d.Get("groups").(*schema.Set); groups.Len() != 2 || !groups.Contains("wheel") {
+		t.Errorf("User groups not read correctly: %v", groups.List())
+	}
+}
+
+func TestUserReadMissing(t *testing.T) {
+	client, _ := testFakeClient(nil)
+	d := schema.TestResourceDataRaw(t, userResource().Schema, map[string]interface{}{"name": "testuser"})
+	d.SetId("1024")
+
+	if diags := userResourceRead(context.Background(), d, client); diags.HasError() {
+		t.Fatalf("Missing user shouldn't be an error: %v", diags)
+	}
+	if d.Id() != "" {
+		t.Errorf("Missing user should be removed from the state")
+	}
+}
+
+func TestUserReadFailure(t *testing.T) {
+	client, _ := testFakeClient(map[string]fakeResult{
+		"getent passwd 1024": {stderr: "getent: cannot reach the directory", exitStatus: 1},
+	})
+	d := schema.TestResourceDataRaw(t, userResource().Schema, map[string]interface{}{"name": "testuser"})
+	d.SetId("1024")
+
+	if diags := userResourceRead(context.Background(), d, client); !diags.HasError() {
+		t.Errorf("Failing lookup should be an error")
+	}
+	if d.Id() != "1024" {
+		t.Errorf("User shouldn't be removed from the state when the lookup fails")
+	}
+}
+
 const userConfig = `
 resource "linux_user" "testuser" {
 	name = "testuser"
@@ -0,0 +1,93 @@
+package linux
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// scriptExport collects the commands that would change the host into a shell script, instead of running
+// them.
+type scriptExport struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+const scriptHeader = "#!/bin/sh\nset -e\n"
+
+func openScriptExport(path string) (*scriptExport, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0700)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to open script_export")
+	}
+	return &scriptExport{file: file}, nil
+}
+
+// add appends command to the script, with the resource it's for as a comment. The header is written first
+// if the script is empty.
+func (s *scriptExport) add(ctx context.Context, command string) error {
+	var script strings.Builder
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if info, err := s.file.Stat(); err == nil && info.Size() == 0 {
+		script.WriteString(scriptHeader)
+	}
+	script.WriteString("\n")
+	if resource := auditResource(ctx); resource != "" {
+		fmt.Fprintf(&script, "# %s\n", strings.Replace(resource, "\n", " ", -1))
+	}
+	script.WriteString(command)
+	script.WriteString("\n")
+	if _, err := s.file.WriteString(script.String()); err != nil {
+		return errors.Wrap(err, "Unable to write to script_export")
+	}
+	return nil
+}
+
+// exporting tells whether the commands that change the host are added to the script_export instead of
+// being run.
+func (c *Client) exporting() bool {
+	return c.script != nil
+}
+
+// runMutatingCommand runs command, which changes the host, like runCommand does. When exporting, it's added
+// to the script instead, exactly as it would be run, and succeeds without output.
+func runMutatingCommand(ctx context.Context, client *Client, escalate bool, command string, stdinContent string) (string, string, error) {
+	if !client.exporting() {
+		return runCommand(ctx, client, escalate, command, stdinContent)
+	}
+
+	// The script asks for the become password itself, when it's run, so it isn't written to the script.
+	escalation := client.escalation
+	escalation.Password = ""
+	command, escalated, err := client.escalate(escalate, command, escalation)
+	if err != nil {
+		return "", "", err
+	}
+	if stdinContent != "" {
+		if strings.ContainsRune(stdinContent, 0) {
+			return "", "", fmt.Errorf("Input with NUL bytes can't be exported to a script: %s", command)
+		}
+		command = fmt.Sprintf("printf '%%s' %s | %s", shellQuote(stdinContent), command)
+	}
+	if !escalated {
+		command = client.inEnvironment(command)
+	}
+	log.Printf("Exporting command %s", command)
+	return "", "", client.script.add(ctx, command)
+}
+
+// exportedID is the ID of a user or group whose creation was only exported, so the host can't tell its id:
+// the id if it's set, or else its name, which later reads reject instead of mistaking another one for it.
+func exportedID(id int, name string) string {
+	if id > 0 {
+		return strconv.Itoa(id)
+	}
+	return name
+}
@@ -0,0 +1,98 @@
+package linux
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestScriptExport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apply.sh")
+	script, err := openScriptExport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, executor := testFakeClient(nil)
+	client.script = script
+	client.useSudo = true
+	client.escalation = Escalation{Method: escalationSudo, Password: "secretpassword"}
+
+	file := auditedResource("linux_file", fileResource())
+	d := schema.TestResourceDataRaw(t, file.Schema, map[string]interface{}{
+		"path":        "/etc/motd",
+		"owner":       "root:root",
+		"permissions": 644,
+		"content":     "it's\nhere",
+		"run_as":      "svc",
+	})
+	if diags := file.CreateContext(context.Background(), d, client); diags.HasError() {
+		t.Fatalf("Unable to export file creation: %v", diags)
+	}
+	if err := createGroup(context.Background(), client, "admins", 0, false); err != nil {
+		t.Fatalf("Unable to export group creation: %v", err)
+	}
+	assertCommands(t, executor)
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := scriptHeader + `
+# linux_file./etc/motd
+sudo -u svc sh -c 'touch /etc/motd'
+
+# linux_file./etc/motd
+sudo chown -- root:root /etc/motd
+
+# linux_file./etc/motd
+sudo chmod 644 /etc/motd
+
+# linux_file./etc/motd
+printf '%s' 'it'\''s
+here' | sudo -u svc sh -c 'cat > /etc/motd'
+
+sudo /usr/sbin/groupadd -- admins
+`
+	if string(content) != expected {
+		t.Errorf("Unexpected script:\n%s\nexpected:\n%s", content, expected)
+	}
+	if d.Id() != "/etc/motd" || d.Get("content") != "it's\nhere" {
+		t.Errorf("Exported file should keep its planned values: %q %q", d.Id(), d.Get("content"))
+	}
+}
+
+func TestScriptExportRuns(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "apply.sh")
+	script, err := openScriptExport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &Client{executor: &localExecutor{}, script: script}
+
+	target := filepath.Join(dir, "it's a file")
+	content := "first line\n$(touch injected)\nno newline at the end"
+	if err := createFile(context.Background(), client, target, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeContent(context.Background(), client, target, content); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Fatalf("Exported commands shouldn't run: %v", err)
+	}
+
+	if output, err := exec.Command("sh", path).CombinedOutput(); err != nil {
+		t.Fatalf("Script failed: %v: %s", err, output)
+	}
+	if written, err := os.ReadFile(target); err != nil || string(written) != content {
+		t.Errorf("Script should write the content as is: %q, %v", written, err)
+	}
+	if _, err := os.Stat("injected"); !os.IsNotExist(err) {
+		t.Errorf("Content should not be run")
+	}
+}
@@ -0,0 +1,172 @@
+package linux
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kevinburke/ssh_config"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultPrivateKey    = "$HOME/.ssh/id_rsa"
+	defaultSSHConfigFile = "$HOME/.ssh/config"
+)
+
+func loadSSHConfig(path string) (*ssh_config.Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("Unable to open ssh config %s", path))
+	}
+	defer f.Close()
+
+	config, err := ssh_config.Decode(f)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("Unable to parse ssh config %s", path))
+	}
+	return config, nil
+}
+
+func sshConfigValue(config *ssh_config.Config, alias string, key string) (value string, err error) {
+	// ssh_config panics on Match directives, which it doesn't support.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Unable to read %s for %s from ssh config: %v", key, alias, r)
+		}
+	}()
+	return config.Get(alias, key)
+}
+
+func expandSSHConfigPath(path string, alias string, user string) string {
+	if strings.HasPrefix(path, "~/") {
+		path = filepath.Join("$HOME", path[2:])
+	}
+	path = strings.NewReplacer("%h", alias, "%r", user, "%%", "%").Replace(path)
+	return os.ExpandEnv(path)
+}
+
+// resolveSSHConfig fills in the connection fields of c that were left unset from the entry matching c.Host
+// in the OpenSSH client config. The port and private key count as unset while they have their default value.
+func (c *Config) resolveSSHConfig(config *ssh_config.Config, defaultPrivateKeyPath string) error {
+	alias := c.Host
+
+	hostname, err := sshConfigValue(config, alias, "HostName")
+	if err != nil {
+		return err
+	}
+	if hostname != "" {
+		c.Host = strings.NewReplacer("%h", alias, "%%", "%").Replace(hostname)
+	}
+
+	if c.User == "" {
+		user, err := sshConfigValue(config, alias, "User")
+		if err != nil {
+			return err
+		}
+		c.User = user
+	}
+
+	if c.Port == 22 {
+		port, err := sshConfigValue(config, alias, "Port")
+		if err != nil {
+			return err
+		}
+		if port != "" {
+			c.Port, err = strconv.Atoi(port)
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("Invalid Port for %s in ssh config", alias))
+			}
+		}
+	}
+
+	if c.PrivateKey == defaultPrivateKeyPath {
+		identityFile, err := sshConfigValue(config, alias, "IdentityFile")
+		if err != nil {
+			return err
+		}
+		if identityFile != "" {
+			c.PrivateKey = expandSSHConfigPath(identityFile, alias, c.User)
+		}
+	}
+	return nil
+}
+
+// parseProxyJump parses a single [user@]host[:port] ProxyJump destination.
+func parseProxyJump(jump string) (user string, host string, port int, err error) {
+	if i := strings.LastIndex(jump, "@"); i >= 0 {
+		user, jump = jump[:i], jump[i+1:]
+	}
+
+	host, portString := jump, ""
+	if strings.HasPrefix(jump, "[") {
+		end := strings.Index(jump, "]")
+		if end < 0 {
+			return "", "", 0, fmt.Errorf("Invalid ProxyJump host %q", jump)
+		}
+		host, portString = jump[1:end], strings.TrimPrefix(jump[end+1:], ":")
+	} else if strings.Count(jump, ":") == 1 {
+		i := strings.Index(jump, ":")
+		host, portString = jump[:i], jump[i+1:]
+	}
+
+	port = 22
+	if portString != "" {
+		port, err = strconv.Atoi(portString)
+		if err != nil {
+			return "", "", 0, fmt.Errorf("Invalid port in ProxyJump host %q", jump)
+		}
+	}
+	return user, host, port, nil
+}
+
+// applySSHConfig resolves c through the OpenSSH client config at path. Bastions are taken from ProxyJump
+// unless bastions are configured explicitly, and each jump host is resolved through the same config.
+func (c *Config) applySSHConfig(path string, explicitBastions bool) error {
+	config, err := loadSSHConfig(path)
+	if err != nil {
+		return err
+	}
+	defaultPrivateKeyPath := os.ExpandEnv(defaultPrivateKey)
+
+	alias := c.Host
+	if err := c.resolveSSHConfig(config, defaultPrivateKeyPath); err != nil {
+		return err
+	}
+	if explicitBastions {
+		return nil
+	}
+
+	proxyJump, err := sshConfigValue(config, alias, "ProxyJump")
+	if err != nil {
+		return err
+	}
+	if proxyJump == "" || proxyJump == "none" {
+		return nil
+	}
+	for _, jump := range strings.Split(proxyJump, ",") {
+		user, host, port, err := parseProxyJump(strings.TrimSpace(jump))
+		if err != nil {
+			return err
+		}
+		bastion := Config{
+			Host:           host,
+			Port:           port,
+			User:           user,
+			PrivateKey:     defaultPrivateKeyPath,
+			KnownHostsFile: c.KnownHostsFile,
+			// Like the bastion blocks, bastions from ProxyJump skip the verification only if the host does.
+			InsecureIgnoreHostKey: c.InsecureIgnoreHostKey,
+		}
+		if err := bastion.resolveSSHConfig(config, defaultPrivateKeyPath); err != nil {
+			return err
+		}
+		if bastion.User == "" {
+			bastion.User = c.User
+		}
+		c.Bastions = append(c.Bastions, bastion)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package linux
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+const testSSHConfig = `
+Host web
+  HostName 10.0.2.15
+  Port 2222
+  User deploy
+  IdentityFile ~/.ssh/web_ed25519
+  ProxyJump jump@bastion,10.0.1.4:2200
+
+Host bastion
+  HostName bastion.example.com
+  IdentityFile /keys/bastion
+
+Host *
+  User fallback
+`
+
+func writeTestSSHConfig(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(testSSHConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestApplySSHConfig(t *testing.T) {
+	config := Config{Host: "web", Port: 22, PrivateKey: os.ExpandEnv(defaultPrivateKey)}
+	if err := config.applySSHConfig(writeTestSSHConfig(t), false); err != nil {
+		t.Fatalf("Unable to apply ssh config: %v", err)
+	}
+
+	if config.Host != "10.0.2.15" || config.Port != 2222 || config.User != "deploy" {
+		t.Errorf("Host alias should be resolved: %+v", config)
+	}
+	if config.PrivateKey != os.ExpandEnv("$HOME/.ssh/web_ed25519") {
+		t.Errorf("IdentityFile should be used as the private key: %s", config.PrivateKey)
+	}
+
+	if len(config.Bastions) != 2 {
+		t.Fatalf("ProxyJump should add two bastions: %+v", config.Bastions)
+	}
+	first, second := config.Bastions[0], config.Bastions[1]
+	if first.Host != "bastion.example.com" || first.Port != 22 || first.User != "jump" || first.PrivateKey != "/keys/bastion" {
+		t.Errorf("First jump host should be resolved through the ssh config: %+v", first)
+	}
+	if second.Host != "10.0.1.4" || second.Port != 2200 || second.User != "fallback" {
+		t.Errorf("Second jump host should be resolved through the ssh config: %+v", second)
+	}
+}
+
+func TestApplySSHConfigKeepsExplicitSettings(t *testing.T) {
+	config := Config{
+		Host:       "web",
+		Port:       2022,
+		User:       "admin",
+		PrivateKey: "/keys/admin",
+		Bastions:   []Config{{Host: "other-bastion", Port: 22}},
+	}
+	if err := config.applySSHConfig(writeTestSSHConfig(t), true); err != nil {
+		t.Fatalf("Unable to apply ssh config: %v", err)
+	}
+
+	if config.Host != "10.0.2.15" || config.Port != 2022 || config.User != "admin" || config.PrivateKey != "/keys/admin" {
+		t.Errorf("Explicit settings should take precedence over the ssh config: %+v", config)
+	}
+	if len(config.Bastions) != 1 || config.Bastions[0].Host != "other-bastion" {
+		t.Errorf("Configured bastions should take precedence over ProxyJump: %+v", config.Bastions)
+	}
+}
+
+func TestProviderBastionsOverrideProxyJump(t *testing.T) {
+	// ProxyJump isn't even parsed when bastions are configured, so a broken one doesn't matter.
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte("Host web\n  HostName 10.0.2.15\n  ProxyJump [broken\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	provider := Provider()
+	diags := provider.Configure(context.Background(), terraform.NewResourceConfigRaw(map[string]interface{}{
+		"host":            "web",
+		"ssh_config_file": path,
+		"bastion":         []interface{}{map[string]interface{}{"host": "other-bastion"}},
+	}))
+	if diags.HasError() {
+		t.Fatalf("Unable to configure provider: %v", diags[0].Summary)
+	}
+
+	config := provider.Meta().(*Client).executor.(*sshExecutor).config
+	if config.Host != "10.0.2.15" {
+		t.Errorf("Host alias should be resolved: %+v", config)
+	}
+	if len(config.Bastions) != 1 || config.Bastions[0].Host != "other-bastion" {
+		t.Errorf("Configured bastions should take precedence over ProxyJump: %+v", config.Bastions)
+	}
+}
+
+func TestProviderBastionsInheritSSHConfigUser(t *testing.T) {
+	provider := Provider()
+	diags := provider.Configure(context.Background(), terraform.NewResourceConfigRaw(map[string]interface{}{
+		"host":            "web",
+		"ssh_config_file": writeTestSSHConfig(t),
+		"bastion":         []interface{}{map[string]interface{}{"host": "other-bastion"}},
+	}))
+	if diags.HasError() {
+		t.Fatalf("Unable to configure provider: %v", diags[0].Summary)
+	}
+
+	config := provider.Meta().(*Client).executor.(*sshExecutor).config
+	if config.User != "deploy" {
+		t.Errorf("User should be resolved through the ssh config: %+v", config)
+	}
+	if len(config.Bastions) != 1 || config.Bastions[0].User != "deploy" {
+		t.Errorf("Bastions without a user should inherit the one from the ssh config: %+v", config.Bastions)
+	}
+}
+
+func TestParseProxyJump(t *testing.T) {
+	cases := []struct {
+		jump, user, host string
+		port             int
+	}{
+		{"bastion", "", "bastion", 22},
+		{"jump@bastion:2222", "jump", "bastion", 2222},
+		{"[fe80::1]:2222", "", "fe80::1", 2222},
+		{"jump@fe80::1", "jump", "fe80::1", 22},
+	}
+	for _, c := range cases {
+		user, host, port, err := parseProxyJump(c.jump)
+		if err != nil || user != c.user || host != c.host || port != c.port {
+			t.Errorf("%s parsed as %q %q %d (%v)", c.jump, user, host, port, err)
+		}
+	}
+
+	if _, _, _, err := parseProxyJump("bastion:ssh"); err == nil {
+		t.Errorf("Non-numeric port should be rejected")
+	}
+}
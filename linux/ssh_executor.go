@@ -0,0 +1,294 @@
+package linux
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshExecutor runs commands over an SSH connection, which it dials on first use and redials if it's lost.
+type sshExecutor struct {
+	config *Config
+
+	mutex      sync.Mutex
+	connection *ssh.Client
+	bastions   []*ssh.Client
+	connectErr error
+	// connected tells whether a dial ever succeeded. Only the outcome of the first dial is kept, so that a
+	// failed redial doesn't fail the later commands too.
+	connected bool
+	// dialing is the dial in progress, if any.
+	dialing *dialAttempt
+
+	sftp    *sftp.Client
+	sftpErr error
+}
+
+func (e *sshExecutor) Execute(ctx context.Context, command string, stdin io.Reader, stdout io.Writer, stderr io.Writer) (int, error) {
+	connection, err := e.connect(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "Failed to connect")
+	}
+	session, err := newSession(ctx, connection)
+	if _, refused := err.(*ssh.OpenChannelError); err != nil && !refused && ctx.Err() == nil {
+		// The connection is gone. Nothing has run yet, so it's safe to reconnect and try again.
+		log.Printf("[WARN] Failed to create session, reconnecting: %v", err)
+		connection, err = e.reconnect(ctx, connection)
+		if err == nil {
+			session, err = newSession(ctx, connection)
+		}
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "Failed to create session")
+	}
+	defer session.Close()
+
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = stderr
+	if err := ctx.Err(); err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("Not running command %s", command))
+	}
+	if err := session.Start(command); err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("Unable to run command %s", command))
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// Ask the remote process to stop, then close the session, which also hangs up its input and output
+		// for servers that don't support signals.
+		log.Printf("[WARN] Stopping command %s: %v", command, ctx.Err())
+		session.Signal(ssh.SIGTERM)
+		session.Close()
+		return 0, errors.Wrap(ctx.Err(), fmt.Sprintf("Stopped command %s", command))
+	}
+	if exitErr, ok := err.(*ssh.ExitError); ok {
+		return exitErr.ExitStatus(), nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("Unable to run command %s", command))
+	}
+	return 0, nil
+}
+
+// Sessions refused by the server, for instance because of sshd's MaxSessions or MaxStartups, are retried
+// with exponential backoff from sessionBackoff, up to maxSessionBackoff, for at most maxSessionAttempts.
+const (
+	sessionBackoff     = 250 * time.Millisecond
+	maxSessionBackoff  = 5 * time.Second
+	maxSessionAttempts = 8
+)
+
+// newSession opens a session on connection, retrying while the server refuses it.
+func newSession(ctx context.Context, connection *ssh.Client) (*ssh.Session, error) {
+	backoff := sessionBackoff
+	for attempt := 1; ; attempt++ {
+		session, err := connection.NewSession()
+		if _, refused := err.(*ssh.OpenChannelError); !refused || attempt == maxSessionAttempts {
+			return session, err
+		}
+		log.Printf("[DEBUG] Session attempt %d refused, retrying in %s: %v", attempt, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, err
+		}
+		backoff *= 2
+		if backoff > maxSessionBackoff {
+			backoff = maxSessionBackoff
+		}
+	}
+}
+
+// connect returns the SSH connection, dialing it on first use. The outcome of the first dial is kept, so
+// that concurrent and later callers don't wait for the host again; while it's in progress, they wait for it
+// until their ctx is done. A dial cut short by its ctx isn't kept, and neither is a failed redial once the
+// connection was lost, so the next caller dials again.
+func (e *sshExecutor) connect(ctx context.Context) (*ssh.Client, error) {
+	for {
+		e.mutex.Lock()
+		if e.connection != nil || e.connectErr != nil {
+			defer e.mutex.Unlock()
+			return e.connection, e.connectErr
+		}
+		dialing := e.dialing
+		if dialing == nil {
+			e.dialing = &dialAttempt{done: make(chan struct{})}
+			e.mutex.Unlock()
+			return e.dial(ctx)
+		}
+		e.mutex.Unlock()
+
+		select {
+		case <-dialing.done:
+			if dialing.err != nil {
+				return nil, dialing.err
+			}
+		case <-ctx.Done():
+			return nil, errors.Wrap(ctx.Err(), "Gave up waiting for the connection")
+		}
+	}
+}
+
+// dialAttempt is a dial that callers wait for. Its err is set if it failed, unless it was cut short by the
+// ctx of the caller that made it, which doesn't concern the ones waiting for it.
+type dialAttempt struct {
+	done chan struct{}
+	err  error
+}
+
+// sftpClient returns an SFTP client on the connection, starting the subsystem on first use. If the server
+// doesn't offer SFTP, the error is kept until the connection is replaced.
+func (e *sshExecutor) sftpClient(ctx context.Context) (*sftp.Client, error) {
+	if _, err := e.connect(ctx); err != nil {
+		return nil, errors.Wrap(err, "Failed to connect")
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.connection == nil {
+		return nil, fmt.Errorf("Connection was closed")
+	}
+	if e.sftp == nil && e.sftpErr == nil {
+		e.sftp, e.sftpErr = sftp.NewClient(e.connection)
+	}
+	return e.sftp, e.sftpErr
+}
+
+// reconnectSFTP replaces dead, an SFTP client whose session was lost, and returns the new one. If the
+// connection under it doesn't answer either, it's redialed too.
+func (e *sshExecutor) reconnectSFTP(ctx context.Context, dead *sftp.Client) (*sftp.Client, error) {
+	e.mutex.Lock()
+	connection := e.connection
+	if e.sftp == dead {
+		dead.Close()
+		e.sftp, e.sftpErr = nil, nil
+	}
+	e.mutex.Unlock()
+
+	if connection != nil && !answers(connection, e.config.ConnectTimeout) {
+		if _, err := e.reconnect(ctx, connection); err != nil {
+			return nil, errors.Wrap(err, "Failed to connect")
+		}
+	}
+	return e.sftpClient(ctx)
+}
+
+// answers tells whether connection answers a keepalive request within timeout, or at all if timeout is 0.
+func answers(connection *ssh.Client, timeout time.Duration) bool {
+	reply := make(chan error, 1)
+	go func() {
+		_, _, err := connection.SendRequest("keepalive@openssh.com", true, nil)
+		reply <- err
+	}()
+	var expired <-chan time.Time
+	if timeout > 0 {
+		expired = time.After(timeout)
+	}
+	select {
+	case err := <-reply:
+		return err == nil
+	case <-expired:
+		return false
+	}
+}
+
+// Close closes the connection, if it was made.
+func (e *sshExecutor) Close() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.close()
+	return nil
+}
+
+// reconnect replaces dead, a connection that failed, with a new one. If another caller already replaced
+// it, the current connection is returned instead.
+func (e *sshExecutor) reconnect(ctx context.Context, dead *ssh.Client) (*ssh.Client, error) {
+	e.mutex.Lock()
+	if e.connection == dead {
+		log.Printf("[INFO] Reconnecting to %s", e.config.Host)
+		e.close()
+	}
+	e.mutex.Unlock()
+	return e.connect(ctx)
+}
+
+// dial connects and starts the keepalives, without holding e.mutex, so that waiting for the host doesn't
+// block Close and the callers whose ctx is done. It's called by the caller that set e.dialing, which it
+// marks as done once the outcome is stored.
+func (e *sshExecutor) dial(ctx context.Context) (*ssh.Client, error) {
+	connection, bastions, err := e.config.connect(ctx)
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if err == nil {
+		e.connection, e.bastions, e.connected = connection, bastions, true
+	} else if ctx.Err() == nil {
+		e.dialing.err = err
+		if !e.connected {
+			e.connectErr = err
+		}
+	}
+	if err == nil && e.config.KeepaliveInterval > 0 {
+		go keepalive(connection, e.config.KeepaliveInterval)
+	}
+	close(e.dialing.done)
+	e.dialing = nil
+	return connection, err
+}
+
+// close closes the connection and its bastions, while e.mutex is held.
+func (e *sshExecutor) close() {
+	if e.sftp != nil {
+		e.sftp.Close()
+	}
+	e.sftp, e.sftpErr = nil, nil
+	if e.connection != nil {
+		e.connection.Close()
+	}
+	for i := len(e.bastions) - 1; i >= 0; i-- {
+		e.bastions[i].Close()
+	}
+	e.connection, e.bastions, e.connectErr = nil, nil, nil
+}
+
+// keepalive sends a keepalive request on connection every interval, so that idle connections aren't
+// dropped by NATs and firewalls. If a request fails or isn't answered within the interval, the connection
+// is closed, which makes the next command reconnect instead of waiting on a dead connection.
+func keepalive(connection *ssh.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		reply := make(chan error, 1)
+		go func() {
+			_, _, err := connection.SendRequest("keepalive@openssh.com", true, nil)
+			reply <- err
+		}()
+
+		select {
+		case err := <-reply:
+			if err != nil {
+				log.Printf("[WARN] SSH keepalive failed, closing the connection: %v", err)
+				connection.Close()
+				return
+			}
+		case <-time.After(interval):
+			log.Printf("[WARN] SSH keepalive not answered in %s, closing the connection", interval)
+			connection.Close()
+			return
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package linux
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestRunCommandReconnects(t *testing.T) {
+	server := startTestSSHServer(t, &ssh.ServerConfig{PasswordCallback: acceptAnyPassword})
+	client := testSSHClient(t, server, 0)
+
+	if _, _, err := runCommand(context.Background(), client, false, "true", ""); err != nil {
+		t.Fatalf("Command should succeed: %v", err)
+	}
+	server.dropConnections()
+
+	stdout, _, err := runCommand(context.Background(), client, false, "echo reconnected", "")
+	if err != nil || stdout != "reconnected\n" {
+		t.Errorf("Dropped connection should be redialed: %q, %v", stdout, err)
+	}
+}
+
+func TestFailedRedialIsRetried(t *testing.T) {
+	server := startTestSSHServer(t, &ssh.ServerConfig{PasswordCallback: acceptAnyPassword})
+	client := testSSHClient(t, server, 0)
+
+	if _, _, err := runCommand(context.Background(), client, false, "true", ""); err != nil {
+		t.Fatalf("Command should succeed: %v", err)
+	}
+	server.stop()
+	if _, _, err := runCommand(context.Background(), client, false, "true", ""); err == nil {
+		t.Fatalf("Command should fail while the host is down")
+	}
+
+	// The host comes back up, with a new host key, which is pinned too.
+	restarted := startTestSSHServerOnPort(t, &ssh.ServerConfig{PasswordCallback: acceptAnyPassword}, server.port)
+	executor := client.executor.(*sshExecutor)
+	executor.config.HostKeys = append(executor.config.HostKeys, restarted.hostKey)
+	stdout, _, err := runCommand(context.Background(), client, false, "echo reconnected", "")
+	if err != nil || stdout != "reconnected\n" {
+		t.Errorf("A failed redial shouldn't fail the later commands: %q, %v", stdout, err)
+	}
+}
+
+func TestSFTPReconnects(t *testing.T) {
+	server := startTestSSHServer(t, &ssh.ServerConfig{PasswordCallback: acceptAnyPassword})
+	client := testSSHClient(t, server, 0)
+	client.fileTransfer = fileTransferSFTP
+	path := filepath.Join(t.TempDir(), "testfile")
+
+	if err := writeContent(context.Background(), client, path, "before"); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+	server.dropConnections()
+
+	if content, err := readFile(context.Background(), client, path); err != nil || content != "before" {
+		t.Errorf("Dropped connection should be redialed for SFTP: %q, %v", content, err)
+	}
+	server.dropConnections()
+	if err := writeContent(context.Background(), client, path, "after"); err != nil {
+		t.Errorf("Dropped connection should be redialed for SFTP: %v", err)
+	}
+	if content, err := os.ReadFile(path); err != nil || string(content) != "after" {
+		t.Errorf("Content should be written after reconnecting: %q, %v", content, err)
+	}
+}
+
+func TestKeepalive(t *testing.T) {
+	server := startTestSSHServer(t, &ssh.ServerConfig{PasswordCallback: acceptAnyPassword})
+	client := testSSHClient(t, server, 50*time.Millisecond)
+
+	if _, err := client.executor.(*sshExecutor).connect(context.Background()); err != nil {
+		t.Fatalf("Unable to connect: %v", err)
+	}
+	time.Sleep(300 * time.Millisecond)
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.keepalives < 2 {
+		t.Errorf("Keepalives should be sent periodically, got %d", server.keepalives)
+	}
+}
+
+func TestRunCommandCancelled(t *testing.T) {
+	server := startTestSSHServer(t, &ssh.ServerConfig{PasswordCallback: acceptAnyPassword})
+	client := testSSHClient(t, server, 0)
+	marker := filepath.Join(t.TempDir(), "terminated")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	command := fmt.Sprintf("trap 'touch %s; exit 1' TERM; sleep 30 & wait", shellQuote(marker))
+	if _, _, err := runCommand(ctx, client, false, command, ""); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expired context should stop the command: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Command should be stopped when the context expires, took %s", elapsed)
+	}
+
+	for i := 0; i < 50; i++ {
+		if _, err := os.Stat(marker); err == nil {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Errorf("Remote process should be signalled")
+}
+
+// runConcurrently runs count commands on client at once, and returns the errors.
+func runConcurrently(client *Client, count int, command string) []error {
+	errs := make([]error, count)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, _, errs[i] = runCommand(context.Background(), client, false, command, "")
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestMaxSessions(t *testing.T) {
+	server := startTestSSHServer(t, &ssh.ServerConfig{PasswordCallback: acceptAnyPassword})
+	client := testSSHClient(t, server, 0)
+	client.sessions = make(chan struct{}, 2)
+
+	for _, err := range runConcurrently(client, 10, "sleep 0.1") {
+		if err != nil {
+			t.Errorf("Command should succeed: %v", err)
+		}
+	}
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.peakSessions > 2 {
+		t.Errorf("At most 2 sessions should be open at once, got %d", server.peakSessions)
+	}
+}
+
+func TestRefusedSessionsAreRetried(t *testing.T) {
+	server := startTestSSHServer(t, &ssh.ServerConfig{PasswordCallback: acceptAnyPassword})
+	server.maxSessions = 2
+	client := testSSHClient(t, server, 0)
+
+	for _, err := range runConcurrently(client, 6, "sleep 0.1") {
+		if err != nil {
+			t.Errorf("Refused sessions should be retried: %v", err)
+		}
+	}
+}
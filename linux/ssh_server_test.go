@@ -0,0 +1,216 @@
+package linux
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// testSSHServer is an SSH server on a local port. It runs exec requests with the local shell and serves the
+// sftp subsystem from the local filesystem, which is enough to run the provider's commands against it
+// without an sshd.
+type testSSHServer struct {
+	port     int
+	listener net.Listener
+	// hostKey is the fingerprint of the server's host key, to pin in the clients' host_key.
+	hostKey string
+
+	mutex      sync.Mutex
+	conns      []*ssh.ServerConn
+	keepalives int
+
+	// maxSessions makes the server refuse sessions beyond it, like sshd's MaxSessions, if it's set.
+	maxSessions  int
+	sessions     int
+	peakSessions int
+}
+
+func startTestSSHServer(t *testing.T, config *ssh.ServerConfig) *testSSHServer {
+	return startTestSSHServerOnPort(t, config, 0)
+}
+
+func startTestSSHServerOnPort(t *testing.T, config *ssh.ServerConfig, port int) *testSSHServer {
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	server := &testSSHServer{
+		port:     listener.Addr().(*net.TCPAddr).Port,
+		listener: listener,
+		hostKey:  ssh.FingerprintSHA256(hostSigner.PublicKey()),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn, config)
+		}
+	}()
+	return server
+}
+
+func (s *testSSHServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	serverConn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	s.mutex.Lock()
+	s.conns = append(s.conns, serverConn)
+	s.mutex.Unlock()
+
+	go func() {
+		for req := range reqs {
+			if req.Type == "keepalive@openssh.com" {
+				s.mutex.Lock()
+				s.keepalives++
+				s.mutex.Unlock()
+			}
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}()
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		if !s.openSession() {
+			newChannel.Reject(ssh.Prohibited, "too many sessions")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			s.closeSession()
+			continue
+		}
+		go func() {
+			serveSession(channel, requests)
+			s.closeSession()
+		}()
+	}
+}
+
+func serveSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	for req := range requests {
+		if req.Type == "subsystem" && len(req.Payload) >= 4 && string(req.Payload[4:]) == "sftp" {
+			req.Reply(true, nil)
+			server, err := sftp.NewServer(channel)
+			if err != nil {
+				return
+			}
+			server.Serve()
+			channel.SendRequest("exit-status", false, make([]byte, 4))
+			return
+		}
+		if req.Type != "exec" || len(req.Payload) < 4 {
+			req.Reply(false, nil)
+			continue
+		}
+		command := string(req.Payload[4:])
+		req.Reply(true, nil)
+
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Stdout = channel
+		cmd.Stderr = channel.Stderr()
+		// Like sshd, don't wait for the client to close stdin once the command is done.
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return
+		}
+		go func() {
+			io.Copy(stdin, channel)
+			stdin.Close()
+		}()
+		if err := cmd.Start(); err != nil {
+			return
+		}
+		// Like sshd, deliver signals sent while the command runs.
+		go func() {
+			for req := range requests {
+				if req.Type == "signal" && len(req.Payload) > 4 && string(req.Payload[4:]) == string(ssh.SIGTERM) {
+					cmd.Process.Signal(syscall.SIGTERM)
+				}
+				if req.WantReply {
+					req.Reply(false, nil)
+				}
+			}
+		}()
+		status := 0
+		if err := cmd.Wait(); err != nil {
+			status = 255
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				status = exitErr.ExitCode()
+			}
+		}
+		payload := make([]byte, 4)
+		binary.BigEndian.PutUint32(payload, uint32(status))
+		channel.SendRequest("exit-status", false, payload)
+		return
+	}
+}
+
+func (s *testSSHServer) openSession() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.maxSessions > 0 && s.sessions >= s.maxSessions {
+		return false
+	}
+	s.sessions++
+	if s.sessions > s.peakSessions {
+		s.peakSessions = s.sessions
+	}
+	return true
+}
+
+func (s *testSSHServer) closeSession() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sessions--
+}
+
+// dropConnections closes every connection made to the server so far, as a NAT dropping them would.
+func (s *testSSHServer) dropConnections() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+// stop stops listening and drops the connections, as a host that goes down would.
+func (s *testSSHServer) stop() {
+	s.listener.Close()
+	s.dropConnections()
+}
+
+func acceptAnyPassword(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	return nil, nil
+}
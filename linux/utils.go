@@ -1,63 +1,211 @@
 package linux
 
 import (
+	"bytes"
+	"context"
 	"fmt"
-	"io/ioutil"
 	"log"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
-func runCommand(client *Client, sudo bool, command string, stdinContent string) (string, string, error) {
-	if sudo && client.useSudo {
-		command = fmt.Sprintf("sudo %s", command)
+// The most output kept from a command. Output beyond it is still read, so that the command isn't blocked
+// on a full window, but discarded.
+const (
+	maxCommandStdout = 64 << 20
+	maxCommandStderr = 1 << 20
+)
+
+// RemoteCommandError is returned when a command exits with a non-zero status.
+type RemoteCommandError struct {
+	Command    string
+	ExitStatus int
+	Stdout     string
+	Stderr     string
+}
+
+func (e *RemoteCommandError) Error() string {
+	message := fmt.Sprintf("Error running command %s: Process exited with status %d", e.Command, e.ExitStatus)
+	if stderr := strings.TrimSpace(e.Stderr); stderr != "" {
+		message = fmt.Sprintf("%s: %s", message, stderr)
 	}
-	session, err := client.connection.NewSession()
-	if err != nil {
-		return "", "", errors.Wrap(err, "Failed to create session")
+	return message
+}
+
+// getentNotFound is the exit status of getent when the key isn't in the database.
+const getentNotFound = 2
+
+// notFoundError is returned when a file, user or group that's looked up doesn't exist.
+type notFoundError struct {
+	kind  string
+	key   string
+	value interface{}
+}
+
+func (e *notFoundError) Error() string {
+	return fmt.Sprintf("%s not found with %s %v", e.kind, e.key, e.value)
+}
+
+// isNotFound tells whether err is, or wraps, a notFoundError.
+func isNotFound(err error) bool {
+	var notFound *notFoundError
+	return errors.As(err, &notFound)
+}
+
+// commandExitStatus returns the exit status of the command that failed with err, or -1 if err isn't a
+// RemoteCommandError.
+func commandExitStatus(err error) int {
+	var commandErr *RemoteCommandError
+	if errors.As(err, &commandErr) {
+		return commandErr.ExitStatus
 	}
-	stdin, err := session.StdinPipe()
-	defer stdin.Close()
-	if err != nil {
-		return "", "", errors.Wrap(err, "Unable to setup stdin for session")
+	return -1
+}
+
+// cappedBuffer keeps the first max bytes written to it, and discards the rest. It deliberately isn't an
+// io.ReaderFrom, so that io.Copy can't write past the cap.
+type cappedBuffer struct {
+	buffer    bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.buffer.Len(); len(p) > room {
+		b.truncated = true
+		if room > 0 {
+			b.buffer.Write(p[:room])
+		}
+		return len(p), nil
 	}
+	return b.buffer.Write(p)
+}
 
-	stderr, err := session.StderrPipe()
-	if err != nil {
-		return "", "", errors.Wrap(err, "Unable to setup stderr for session")
+func (b *cappedBuffer) String() string {
+	return b.buffer.String()
+}
+
+// runCommand runs command, with stdinContent as its input, and returns its stdout and stderr. If it exits
+// with a non-zero status, the error is a RemoteCommandError.
+func runCommand(ctx context.Context, client *Client, escalate bool, command string, stdinContent string) (string, string, error) {
+	// The facts probe is a fixed command that only reads from the host, but too elaborate for
+	// isReadOnlyCommand to tell.
+	if client.readOnly && command != factsProbe && !isReadOnlyCommand(command) {
+		return "", "", fmt.Errorf("Refusing to run %s: the provider is read_only", command)
 	}
-	stdout, err := session.StdoutPipe()
+
+	original := command
+	command, escalated, err := client.escalate(escalate, command, client.escalation)
 	if err != nil {
-		return "", "", errors.Wrap(err, "Unable to setup stdout for session")
+		return "", "", err
+	}
+	var become *becomeSession
+	if escalated && client.escalation.Password != "" {
+		become = newBecomeSession(client.escalation.Password, stdinContent)
 	}
 
 	log.Printf("Running command %s", command)
 
-	var stdoutOutput, stderrOutput []byte
-	err = session.Start(command)
+	if client.sessions != nil {
+		select {
+		case client.sessions <- struct{}{}:
+			defer func() { <-client.sessions }()
+		case <-ctx.Done():
+			return "", "", errors.Wrap(ctx.Err(), fmt.Sprintf("Gave up waiting for a session to run %s", command))
+		}
+	}
+
+	// Escalated commands already export the environment, inside the escalation.
+	run := command
+	if !escalated {
+		run = client.inEnvironment(command)
+	}
+	stdout := &cappedBuffer{max: maxCommandStdout}
+	stderr := &cappedBuffer{max: maxCommandStderr}
+	var exitStatus int
+	start := time.Now()
+	if become != nil {
+		exitStatus, err = client.executor.Execute(ctx, run, become.stdin, stdout, become)
+		become.close()
+		output, rejected := become.output()
+		stderr.Write([]byte(output))
+		stderr.truncated = become.stderr.truncated
+		if err == nil && rejected {
+			err = fmt.Errorf("Incorrect become_password")
+		}
+	} else {
+		exitStatus, err = client.executor.Execute(ctx, run, strings.NewReader(stdinContent), stdout, stderr)
+	}
+	if auditErr := client.auditCommand(ctx, original, escalated, stdinContent, start, exitStatus, err); auditErr != nil {
+		return "", "", auditErr
+	}
 	if err != nil {
-		return "", "", errors.Wrap(err, fmt.Sprintf("Unable to start command %s", command))
+		return "", "", err
+	}
+	if stderr.truncated {
+		stderr.buffer.WriteString("\n(stderr truncated)")
+	}
+	if exitStatus != 0 {
+		log.Printf("Stderr output: %s", strings.TrimSpace(stderr.String()))
+		return stdout.String(), stderr.String(), &RemoteCommandError{
+			Command:    command,
+			ExitStatus: exitStatus,
+			Stdout:     stdout.String(),
+			Stderr:     stderr.String(),
+		}
 	}
-	if stdinContent != "" {
-		stdin.Write([]byte(stdinContent))
-		stdin.Close()
+	if stdout.truncated {
+		return stdout.String(), stderr.String(), fmt.Errorf("Output of command %s is larger than %d bytes", command, maxCommandStdout)
 	}
-	err = session.Wait()
 
-	if err != nil {
-		stderrOutput, err2 := ioutil.ReadAll(stderr)
-		if err2 != nil {
-			log.Printf("Unable to read stderr for command: %v", err)
+	return stdout.String(), stderr.String(), nil
+}
+
+// escalate returns command as it's run with escalation: escalated if it needs root privileges and the client
+// uses sudo, or as the client's run-as user if it doesn't. The flag tells whether it was escalated.
+func (c *Client) escalate(escalate bool, command string, escalation Escalation) (string, bool, error) {
+	escalated, user := escalate && c.useSudo, ""
+	if !escalate && c.runAs != "" {
+		if escalation.Method == escalationNone {
+			return "", false, fmt.Errorf("Running commands as %s needs an escalation method", c.runAs)
 		}
-		log.Printf("Stderr output: %s", strings.TrimSpace(string(stderrOutput)))
+		escalated, user = true, c.runAs
+	}
+	if escalated {
+		// The environment is set inside the escalation, so that sudo's env_reset and the login environment of
+		// su don't drop it.
+		command = escalation.command(user, c.environment, command)
+	}
+	return command, escalated, nil
+}
 
-		return string(stdoutOutput), string(stderrOutput), errors.Wrap(err, fmt.Sprintf("Error running command %s", command))
+// shellCommand returns the command line running args, with each argument quoted as a single shell word.
+func shellCommand(args ...string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
 	}
-	stdoutOutput, err = ioutil.ReadAll(stdout)
-	if err != nil {
-		return string(stdoutOutput), string(stderrOutput), errors.Wrap(err, fmt.Sprintf("Unable to read stdout for command %s", command))
+	return strings.Join(quoted, " ")
+}
+
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9@%+=:,./_-]+$`)
+
+// shellQuote quotes s as a single word for a POSIX shell. Words that don't need quoting are left as they are,
+// which keeps the commands in the logs readable.
+func shellQuote(s string) string {
+	if shellSafe.MatchString(s) {
+		return s
 	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
 
-	return string(stdoutOutput), string(stderrOutput), nil
+func expandStringList(list []interface{}) []string {
+	result := make([]string, len(list))
+	for i, v := range list {
+		result[i] = v.(string)
+	}
+	return result
 }
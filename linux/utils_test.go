@@ -0,0 +1,192 @@
+package linux
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// testSSHClient returns a client for server, which accepts any password.
+func testSSHClient(t *testing.T, server *testSSHServer, keepaliveInterval time.Duration) *Client {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	config := Config{
+		Host:              "127.0.0.1",
+		Port:              server.port,
+		User:              "admin",
+		Password:          "secret",
+		HostKeys:          []string{server.hostKey},
+		ConnectTimeout:    5 * time.Second,
+		KeepaliveInterval: keepaliveInterval,
+	}
+	client, err := config.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.executor.(*sshExecutor).Close() })
+	return client
+}
+
+func TestRunCommand(t *testing.T) {
+	server := startTestSSHServer(t, &ssh.ServerConfig{PasswordCallback: acceptAnyPassword})
+	client := testSSHClient(t, server, 0)
+
+	stdout, _, err := runCommand(context.Background(), client, false, "echo hello", "")
+	if err != nil || stdout != "hello\n" {
+		t.Errorf("Command should print hello: %q, %v", stdout, err)
+	}
+
+	stdout, _, err = runCommand(context.Background(), client, false, "cat", "some content")
+	if err != nil || stdout != "some content" {
+		t.Errorf("Stdin should be passed to the command: %q, %v", stdout, err)
+	}
+
+	if _, _, err := runCommand(context.Background(), client, false, "exit 3", ""); err == nil {
+		t.Errorf("Failing command should return an error")
+	}
+}
+
+// fakeResult is the outcome of a command run by fakeExecutor.
+type fakeResult struct {
+	stdout     string
+	stderr     string
+	exitStatus int
+}
+
+// fakeExecutor answers commands from a fixed set of results, and records the commands it runs along with
+// their input. Commands without a result succeed with no output.
+type fakeExecutor struct {
+	results  map[string]fakeResult
+	commands []string
+	stdins   []string
+}
+
+func (e *fakeExecutor) Execute(ctx context.Context, command string, stdin io.Reader, stdout io.Writer, stderr io.Writer) (int, error) {
+	input, err := io.ReadAll(stdin)
+	if err != nil {
+		return 0, err
+	}
+	e.commands = append(e.commands, command)
+	e.stdins = append(e.stdins, string(input))
+
+	result := e.results[command]
+	io.WriteString(stdout, result.stdout)
+	io.WriteString(stderr, result.stderr)
+	return result.exitStatus, nil
+}
+
+func testFakeClient(results map[string]fakeResult) (*Client, *fakeExecutor) {
+	executor := &fakeExecutor{results: results}
+	return &Client{executor: executor}, executor
+}
+
+func assertCommands(t *testing.T, executor *fakeExecutor, expected ...string) {
+	t.Helper()
+	if !reflect.DeepEqual(executor.commands, expected) {
+		t.Errorf("Expected commands:\n  %s\ngot:\n  %s", strings.Join(expected, "\n  "), strings.Join(executor.commands, "\n  "))
+	}
+}
+
+func TestRunCommandWithSudo(t *testing.T) {
+	client, executor := testFakeClient(nil)
+	client.useSudo = true
+
+	runCommand(context.Background(), client, true, "chmod 644 /etc/testfile", "")
+	runCommand(context.Background(), client, false, "cat /etc/testfile", "")
+	assertCommands(t, executor, "sudo chmod 644 /etc/testfile", "cat /etc/testfile")
+}
+
+func TestRunCommandExitStatus(t *testing.T) {
+	client, _ := testFakeClient(map[string]fakeResult{
+		"ls -ld /missing": {stderr: "No such file or directory", exitStatus: 2},
+	})
+
+	_, stderr, err := runCommand(context.Background(), client, false, "ls -ld /missing", "")
+	if err == nil || !strings.Contains(err.Error(), "status 2") {
+		t.Errorf("Non-zero exit status should be an error: %v", err)
+	}
+	if stderr != "No such file or directory" {
+		t.Errorf("Stderr should be returned: %q", stderr)
+	}
+
+	var commandErr *RemoteCommandError
+	if !errors.As(errors.Wrap(err, "Command failed"), &commandErr) {
+		t.Fatalf("Error should be a RemoteCommandError: %T", err)
+	}
+	if commandErr.Command != "ls -ld /missing" || commandErr.ExitStatus != 2 || commandErr.Stderr != "No such file or directory" {
+		t.Errorf("RemoteCommandError should describe the command: %+v", commandErr)
+	}
+}
+
+func TestRunCommandLargeOutput(t *testing.T) {
+	server := startTestSSHServer(t, &ssh.ServerConfig{PasswordCallback: acceptAnyPassword})
+	client := testSSHClient(t, server, 0)
+
+	// Far more than the SSH window, on both streams at once, and failing, which used to hang.
+	command := "head -c 8388608 /dev/zero; head -c 8388608 /dev/zero >&2; exit 1"
+	stdout, stderr, err := runCommand(context.Background(), client, false, command, "")
+	if commandExitStatus(err) != 1 {
+		t.Fatalf("Command should fail with its exit status: %v", err)
+	}
+	if len(stdout) != 8<<20 {
+		t.Errorf("Stdout should be kept on failure, got %d bytes", len(stdout))
+	}
+	if !strings.HasSuffix(stderr, "(stderr truncated)") || len(stderr) > maxCommandStderr+100 {
+		t.Errorf("Stderr should be capped, got %d bytes", len(stderr))
+	}
+}
+
+func TestCappedBuffer(t *testing.T) {
+	b := &cappedBuffer{max: 5}
+	for _, s := range []string{"abc", "defg", "hij"} {
+		if n, err := b.Write([]byte(s)); n != len(s) || err != nil {
+			t.Errorf("Writes should always succeed, so that output is drained: %d, %v", n, err)
+		}
+	}
+	if b.String() != "abcde" || !b.truncated {
+		t.Errorf("Buffer should keep the first 5 bytes: %q, %v", b.String(), b.truncated)
+	}
+}
+
+// hostileValues are values that break or inject into commands that aren't quoted.
+var hostileValues = []string{
+	"",
+	"with space",
+	"it's",
+	"$(touch injected)",
+	"`touch injected`",
+	"; touch injected",
+	"a\nb",
+	"\"quoted\" \\ $HOME *",
+	"-rf",
+}
+
+func TestShellQuote(t *testing.T) {
+	dir := t.TempDir()
+	executor := &localExecutor{}
+	for _, value := range hostileValues {
+		var stdout, stderr bytes.Buffer
+		command := "cd " + shellQuote(dir) + " && " + shellCommand("printf", "%s|", value, value)
+		if _, err := executor.Execute(context.Background(), command, strings.NewReader(""), &stdout, &stderr); err != nil {
+			t.Fatal(err)
+		}
+		if stdout.String() != value+"|"+value+"|" {
+			t.Errorf("%q should be passed as a single argument, got %q (%s)", value, stdout.String(), stderr.String())
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "injected")); err == nil {
+		t.Errorf("Quoted values shouldn't run commands")
+	}
+
+	if quoted := shellCommand("chown", "testuser:testgroup", "/etc/testfile"); quoted != "chown testuser:testgroup /etc/testfile" {
+		t.Errorf("Safe words shouldn't be quoted: %s", quoted)
+	}
+}
@@ -5,4 +5,6 @@ docker run -d -p 5001:22 --name tf_linux_test_sshd rastasheep/ubuntu-sshd:18.04
 export TF_LINUX_SSH_USER=root
 export TF_LINUX_SSH_HOST=127.0.0.1
 export TF_LINUX_SSH_PORT=5001
-export TF_LINUX_SSH_PASSWORD=root
\ No newline at end of file
+export TF_LINUX_SSH_PASSWORD=root
+# The container is created for the tests, so its host key can't be known in advance.
+export TF_LINUX_SSH_INSECURE_IGNORE_HOST_KEY=true